@@ -102,6 +102,7 @@ func routeAmazonAlexaHomeConnectHandle(w http.ResponseWriter, r *http.Request) {
 	}
 	if valid, _ := verifyAuthToken(accessToken, scopeYandexHome); !valid {
 		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
 	}
 
 	response := AlexaResponseEnvelope{
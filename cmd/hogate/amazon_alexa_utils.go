@@ -26,6 +26,11 @@ type alexaCertificate struct {
 var alexaCertificates map[string]*alexaCertificate
 var alexaCertificatesLock sync.Mutex
 
+// alexaSimulatorKey verifies the requests signed by the simulate action, it is never set when the service runs
+var alexaSimulatorKey *ecdsa.PublicKey
+
+const alexaSimulatorCertURL = "simulator:"
+
 func init() {
 	alexaCertificates = make(map[string]*alexaCertificate)
 }
@@ -141,6 +146,9 @@ func signatureCertificate(certificateUrl string) (x509.PublicKeyAlgorithm, any)
 	if certificateUrl == "" {
 		return x509.UnknownPublicKeyAlgorithm, nil
 	}
+	if alexaSimulatorKey != nil && certificateUrl == alexaSimulatorCertURL {
+		return x509.ECDSA, alexaSimulatorKey
+	}
 	certificateUrl, err := url.JoinPath(certificateUrl)
 	if err != nil {
 		return x509.UnknownPublicKeyAlgorithm, nil
@@ -1,14 +1,13 @@
 package main
 
 import (
-	"compress/gzip"
 	"fmt"
-	"html"
+	"mime"
 	"net/http"
-	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,6 +28,15 @@ func validateAssetConfig(cfgError configError) {
 		}
 
 		validateRoutePropertiesConfig(ast, &ast.routeBase, assetError)
+
+		if (a.Flags&HAFGZipContent) != 0 && ast.compression == nil {
+			ast.compression = newCompressionOptions()
+		}
+		ast.ownOptions = (a.Flags & HAFWebDAV) != 0
+		if ast.compression != nil && len(ast.compression.includes) == 0 && len(ast.compression.excludes) == 0 {
+			ast.compression.includes = a.GzipIncludes
+			ast.compression.excludes = a.GzipExcludes
+		}
 	}
 }
 
@@ -46,11 +54,15 @@ func addAssetRoutes(router *http.ServeMux) map[string]struct{} {
 				i++
 			}
 
-			var handler http.Handler = a
-			if (a.Flags & HAFGZipContent) != 0 {
-				handler = gzipHandler(handler, a.GzipIncludes, a.GzipExcludes, gzip.BestCompression)
+			if (a.Flags & HAFWebDAV) != 0 {
+				a.parsedWriteScope = nil
+				for k := range parseScope(a.WriteScope) {
+					a.parsedWriteScope = append(a.parsedWriteScope, k)
+				}
+				a.webdavHandler = a.newWebDAVHandler()
 			}
-			router.Handle(ast.Route, ast.applyHandlers(handler))
+
+			router.Handle(ast.Route, ast.applyHandlers(a))
 
 			routes[a.Route] = struct{}{}
 		}
@@ -72,6 +84,46 @@ func (a *asset) valid(routes map[string]struct{}) error {
 			return fmt.Errorf("the route '%s' has invalid include pattern '%s'", a.Route, pattern)
 		}
 	}
+	if (a.Flags&HAFWebDAV) != 0 && len(parseScope(a.WriteScope)) == 0 {
+		return fmt.Errorf("the route '%s' has webdav set without writeScope", a.Route)
+	}
+	if (a.Flags & HAFZipDownload) != 0 {
+		if err := a.validZipLimits(); err != nil {
+			return err
+		}
+	}
+	if (a.Flags & HAFThumbnails) != 0 {
+		if err := a.validThumbnails(); err != nil {
+			return err
+		}
+	}
+	if err := a.validCacheControl(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *asset) validCacheControl() error {
+	a.cacheControl = a.CacheControl
+	if a.cacheControl != "" {
+		return nil
+	}
+	if a.MaxAge != "" {
+		maxAge, err := parseMaxAge(a.MaxAge)
+		if err == nil && maxAge < 0 {
+			err = fmt.Errorf("negative value not allowed")
+		}
+		if err != nil {
+			return fmt.Errorf("the route '%s' has invalid maxAge '%s': %v", a.Route, a.MaxAge, err)
+		}
+		a.cacheControl = "max-age=" + strconv.FormatInt(int64(maxAge/time.Second), 10)
+	}
+	if a.Immutable {
+		if a.cacheControl == "" {
+			return fmt.Errorf("the route '%s' has immutable set without maxAge", a.Route)
+		}
+		a.cacheControl += ", immutable"
+	}
 	return nil
 }
 
@@ -85,59 +137,24 @@ func (a *asset) checkVisibility(trgPath string) bool {
 			return false
 		}
 	}
-	if skipByPatterns(
-		a.Includes, a.Excludes,
-		[]string{trgPath, filepath.Base(trgPath)},
-		func(pattern, value string) bool {
-			m, err := filepath.Match(pattern, value)
-			return m && err != nil
-		},
-	) {
+	if skipByPatterns(a.Includes, a.Excludes, []string{trgPath, filepath.Base(trgPath)}, assetMatchPattern) {
 		return false
 	}
 	return true
 }
 
-func (a *asset) dirListing(w http.ResponseWriter, r *http.Request, trgPath string, modtime time.Time, root bool) {
-	if !modtime.IsZero() {
-		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
-		if r.Method == "GET" || r.Method == "HEAD" {
-			ims := r.Header.Get("If-Modified-Since")
-			for _, layout := range []string{http.TimeFormat, time.RFC850, time.ANSIC} {
-				t, err := time.Parse(layout, ims)
-				if err == nil {
-					mt := modtime.Truncate(time.Second)
-					if mt.Before(t) || mt.Equal(t) {
-						return
-					}
-					break
-				}
-			}
-		}
-	}
-
-	files, err := os.ReadDir(trgPath)
-	if err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
+// checkDirVisibility tells if the content of the directory could be visible, the include patterns apply to the content only
+func (a *asset) checkDirVisibility(trgPath string) bool {
+	name := filepath.Base(trgPath)
+	if (a.Flags&HAFShowHidden) == 0 && name[0:1] == "." {
+		return false
 	}
+	return !skipByPatterns(nil, a.Excludes, []string{trgPath, name}, assetMatchPattern)
+}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	fmt.Fprintf(w, "<pre>\n")
-	if !root {
-		fmt.Fprintf(w, "<a href=\"..\">..</a>\n")
-	}
-	for _, file := range files {
-		name := file.Name()
-		if a.checkVisibility(filepath.Join(trgPath, name)) {
-			if file.IsDir() {
-				name += "/"
-			}
-			url := url.URL{Path: name}
-			fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(url.String()), html.EscapeString(name))
-		}
-	}
-	fmt.Fprintf(w, "</pre>\n")
+func assetMatchPattern(pattern, value string) bool {
+	m, err := filepath.Match(pattern, value)
+	return m && err == nil
 }
 
 func (a *asset) authorize(w http.ResponseWriter, r *http.Request) bool {
@@ -145,31 +162,14 @@ func (a *asset) authorize(w http.ResponseWriter, r *http.Request) bool {
 		return false
 	}
 
-	status, _ := testAuthorization(r, a.parsedScope...)
-	if status != http.StatusOK {
-		if (a.Flags & HAFAuthorize) != 0 {
-			targetURL := fmt.Sprintf(
-				"%s?redirect_uri=%s",
-				dedicatedRoutes[routeLogin].path, url.QueryEscape(r.URL.String()),
-			)
-			if len(a.parsedScope) > 0 {
-				targetURL = fmt.Sprintf(
-					"%s&scope=%s",
-					targetURL, url.QueryEscape(strings.Join(a.parsedScope, ",")),
-				)
-			}
-			w.Header().Set("Location", targetURL)
-			w.WriteHeader(http.StatusFound)
-		} else {
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-		}
-		return true
-	}
-
-	return false
+	return authorizeRequest(w, r, (a.Flags&HAFAuthorize) != 0, a.parsedScope)
 }
 
 func (a *asset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (a.Flags&HAFWebDAV) != 0 && a.serveWebDAV(w, r) {
+		return
+	}
+
 	if a.authorize(w, r) {
 		return
 	}
@@ -256,6 +256,11 @@ func (a *asset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if fi.IsDir() {
+		if (a.Flags&HAFZipDownload) != 0 && r.URL.Query().Get("download") == "zip" {
+			a.zipDownload(w, r, trgPath)
+			return
+		}
+
 		noIndexFile := true
 		if root && len(a.IndexFiles) > 0 {
 			for _, indexFile := range a.IndexFiles {
@@ -281,7 +286,28 @@ func (a *asset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	f, err := os.Open(trgPath)
+	if (a.Flags&HAFThumbnails) != 0 && r.URL.Query().Has("thumbnail") {
+		a.thumbnail(w, r, trgPath, fi)
+		return
+	}
+
+	if a.cacheControl != "" {
+		w.Header().Set("Cache-Control", a.cacheControl)
+	}
+
+	// ranges are always served from the identity content, so the seeking in media files works
+	contentPath := trgPath
+	if (a.Flags & HAFPrecompressed) != 0 {
+		if path, pfi, encoding := a.precompressedFile(r, trgPath); path != "" && r.Header.Get("Range") == "" {
+			contentPath = path
+			fi = pfi
+			w.Header().Set("Content-Type", mime.TypeByExtension(filepath.Ext(trgPath)))
+			w.Header().Set("Content-Encoding", encoding)
+		}
+		addVary(w.Header(), "Accept-Encoding")
+	}
+
+	f, err := os.Open(contentPath)
 	if err != nil {
 		httpSetLogBulkData(r, logData{
 			"asset": {"error": err.Error()},
@@ -291,5 +317,15 @@ func (a *asset) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer f.Close()
 
+	if (a.Flags & HAFETag) != 0 {
+		if etag, err := assetETag(contentPath, fi, f); err == nil {
+			w.Header().Set("ETag", etag)
+		} else {
+			httpSetLogBulkData(r, logData{
+				"asset": {"etagError": err.Error()},
+			})
+		}
+	}
+
 	http.ServeContent(w, r, trgPath, fi.ModTime(), f)
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// precompressed sibling files in the order of preference
+var assetPrecompressed = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"zstd", ".zst"},
+	{"gzip", ".gz"},
+}
+
+type assetETagKey struct {
+	path    string
+	size    int64
+	modTime int64
+}
+
+const assetETagCacheSize = 4096
+
+var assetETags = make(map[assetETagKey]string)
+var assetETagsLock sync.Mutex
+
+// parseAcceptEncoding returns accepted encodings with their q-values
+func parseAcceptEncoding(r *http.Request) map[string]float64 {
+	rv := make(map[string]float64)
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			params := strings.Split(item, ";")
+			encoding := strings.ToLower(strings.TrimSpace(params[0]))
+			if encoding == "" {
+				continue
+			}
+			q := 1.0
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = v
+					}
+				}
+			}
+			rv[encoding] = q
+		}
+	}
+	return rv
+}
+
+func acceptsEncoding(accepted map[string]float64, encoding string) bool {
+	q, ok := accepted[encoding]
+	if !ok {
+		q, ok = accepted["*"]
+	}
+	return ok && q > 0
+}
+
+// precompressedFile looks for the compressed sibling of the file acceptable by the client
+func (a *asset) precompressedFile(r *http.Request, trgPath string) (string, os.FileInfo, string) {
+	if mime.TypeByExtension(filepath.Ext(trgPath)) == "" {
+		return "", nil, "" // content type could not be sniffed from compressed content
+	}
+	accepted := parseAcceptEncoding(r)
+	for _, pc := range assetPrecompressed {
+		if !acceptsEncoding(accepted, pc.encoding) {
+			continue
+		}
+		path := trgPath + pc.extension
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path, fi, pc.encoding
+		}
+	}
+	return "", nil, ""
+}
+
+// assetETag returns the strong entity tag computed from the file content; f is rewound to the start
+func assetETag(path string, fi os.FileInfo, f io.ReadSeeker) (string, error) {
+	key := assetETagKey{path: path, size: fi.Size(), modTime: fi.ModTime().UnixNano()}
+
+	assetETagsLock.Lock()
+	etag, ok := assetETags[key]
+	assetETagsLock.Unlock()
+	if ok {
+		return etag, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag = `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:18]) + `"`
+
+	assetETagsLock.Lock()
+	if len(assetETags) >= assetETagCacheSize {
+		for k := range assetETags {
+			delete(assetETags, k)
+			if len(assetETags) < assetETagCacheSize/2 {
+				break
+			}
+		}
+	}
+	assetETags[key] = etag
+	assetETagsLock.Unlock()
+
+	return etag, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type dirListingEntry struct {
+	Name      string    `json:"name"`
+	Dir       bool      `json:"dir,omitempty"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+	URL       string    `json:"url"`
+	Thumbnail string    `json:"thumbnail,omitempty"`
+}
+
+type dirListingCrumb struct {
+	Name string
+	URL  string
+}
+
+type dirListingPage struct {
+	Path    string            `json:"path"`
+	Entries []dirListingEntry `json:"entries"`
+
+	Crumbs []dirListingCrumb `json:"-"`
+	Root   bool              `json:"-"`
+	Zip    bool              `json:"-"`
+	Sort   string            `json:"-"`
+	Desc   bool              `json:"-"`
+}
+
+var dirListingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"size": func(size int64) string {
+		const units = "KMGTPE"
+		if size < 1024 {
+			return strconv.FormatInt(size, 10) + " B"
+		}
+		v, i := float64(size)/1024, 0
+		for v >= 1024 && i < len(units)-1 {
+			v /= 1024
+			i++
+		}
+		return strconv.FormatFloat(v, 'f', 1, 64) + " " + units[i:i+1] + "B"
+	},
+	"date": func(t time.Time) string {
+		return t.Local().Format("2006-01-02 15:04")
+	},
+	"sortURL": func(p *dirListingPage, key string) string {
+		order := "asc"
+		if p.Sort == key && !p.Desc {
+			order = "desc"
+		}
+		return "?sort=" + key + "&order=" + order
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>{{.Path}}</title>
+	<style>
+		body{font-family:sans-serif;margin:1em;}
+		#hgd-c a{text-decoration:none;}
+		#hgd-t{border-collapse:collapse;margin-top:1em;}
+		#hgd-t th,#hgd-t td{padding:0.2em 1em 0.2em 0;text-align:left;vertical-align:middle;}
+		#hgd-t td.hgd-n{text-align:right;white-space:nowrap;}
+		.hgd-i{max-width:64px;max-height:64px;}
+	</style>
+</head>
+<body>
+	<div id="hgd-c">{{range $i, $c := .Crumbs}}{{if $i}} / {{end}}<a href="{{$c.URL}}">{{$c.Name}}</a>{{end}}</div>
+	{{if .Zip}}<div><a href="?download=zip">Download as zip</a></div>{{end}}
+	<table id="hgd-t">
+		<tr>
+			<th></th>
+			<th><a href="{{sortURL . "name"}}">Name</a></th>
+			<th><a href="{{sortURL . "size"}}">Size</a></th>
+			<th><a href="{{sortURL . "time"}}">Modified</a></th>
+		</tr>
+		{{if not .Root}}<tr><td></td><td><a href="..">..</a></td><td></td><td></td></tr>{{end}}
+		{{range .Entries}}<tr>
+			<td>{{if .Thumbnail}}<a href="{{.URL}}"><img class="hgd-i" src="{{.Thumbnail}}" alt="" loading="lazy"></a>{{end}}</td>
+			<td><a href="{{.URL}}">{{.Name}}{{if .Dir}}/{{end}}</a></td>
+			<td class="hgd-n">{{if not .Dir}}{{size .Size}}{{end}}</td>
+			<td class="hgd-n">{{date .Modified}}</td>
+		</tr>{{end}}
+	</table>
+</body>
+</html>
+`))
+
+func (a *asset) dirListing(w http.ResponseWriter, r *http.Request, trgPath string, modtime time.Time, root bool) {
+	addVary(w.Header(), "Accept")
+
+	files, err := os.ReadDir(trgPath)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	page := &dirListingPage{
+		Path:    r.URL.Path,
+		Root:    root,
+		Zip:     (a.Flags & HAFZipDownload) != 0,
+		Sort:    r.URL.Query().Get("sort"),
+		Desc:    r.URL.Query().Get("order") == "desc",
+		Entries: make([]dirListingEntry, 0, len(files)),
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if !a.checkVisibility(filepath.Join(trgPath, name)) {
+			continue
+		}
+		fi, err := file.Info()
+		if err != nil {
+			continue
+		}
+		entry := dirListingEntry{Name: name, Dir: file.IsDir(), Modified: fi.ModTime().UTC()}
+		if entry.Dir {
+			entry.URL = (&url.URL{Path: name + "/"}).String()
+		} else {
+			entry.Size = fi.Size()
+			entry.URL = (&url.URL{Path: name}).String()
+			if (a.Flags&HAFThumbnails) != 0 && thumbnailSupported(name) {
+				entry.Thumbnail = entry.URL + "?thumbnail"
+			}
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	page.sortEntries()
+
+	representation := "html"
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		representation = "json"
+	}
+	if page.notModified(w, r, modtime, representation) {
+		return
+	}
+
+	if representation == "json" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if r.Method != "HEAD" {
+			json.NewEncoder(w).Encode(page)
+		}
+		return
+	}
+
+	page.Crumbs = dirListingCrumbs(a.Route, r.URL.Path)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method != "HEAD" {
+		if err := dirListingTemplate.Execute(w, page); err != nil {
+			httpSetLogBulkData(r, logData{
+				"asset": {"error": err.Error()},
+			})
+		}
+	}
+}
+
+// notModified sets the validators computed from the directory, its entries and the representation, and replies 304 if the client's copy is current
+func (p *dirListingPage) notModified(w http.ResponseWriter, r *http.Request, modtime time.Time, representation string) bool {
+	h := fnv.New64a()
+	fmt.Fprintln(h, representation)
+	for _, e := range p.Entries {
+		if e.Modified.After(modtime) {
+			modtime = e.Modified
+		}
+		fmt.Fprintf(h, "%s|%v|%v|%v\n", e.Name, e.Dir, e.Size, e.Modified.UnixNano())
+	}
+	etag := fmt.Sprintf("W/\"%x\"", h.Sum64())
+	w.Header().Set("ETag", etag)
+	if !modtime.IsZero() {
+		w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || tag == etag[2:] {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+		return false
+	}
+
+	if !modtime.IsZero() {
+		ims := r.Header.Get("If-Modified-Since")
+		for _, layout := range []string{http.TimeFormat, time.RFC850, time.ANSIC} {
+			t, err := time.Parse(layout, ims)
+			if err == nil {
+				mt := modtime.Truncate(time.Second)
+				if mt.Before(t) || mt.Equal(t) {
+					w.WriteHeader(http.StatusNotModified)
+					return true
+				}
+				break
+			}
+		}
+	}
+	return false
+}
+
+func (p *dirListingPage) sortEntries() {
+	less := func(i, j int) bool {
+		return strings.ToLower(p.Entries[i].Name) < strings.ToLower(p.Entries[j].Name)
+	}
+	switch p.Sort {
+	case "size":
+		less = func(i, j int) bool { return p.Entries[i].Size < p.Entries[j].Size }
+	case "time":
+		less = func(i, j int) bool { return p.Entries[i].Modified.Before(p.Entries[j].Modified) }
+	default:
+		p.Sort = "name"
+	}
+	sort.SliceStable(p.Entries, func(i, j int) bool {
+		// directories always go first
+		if p.Entries[i].Dir != p.Entries[j].Dir {
+			return p.Entries[i].Dir
+		}
+		if p.Desc {
+			return less(j, i)
+		}
+		return less(i, j)
+	})
+}
+
+// dirListingCrumbs returns the links to the parent directories, starting from the asset route
+func dirListingCrumbs(route, urlPath string) []dirListingCrumb {
+	crumbs := []dirListingCrumb{{Name: "/", URL: (&url.URL{Path: route}).String()}}
+	current := route
+	for _, name := range strings.Split(strings.Trim(strings.TrimPrefix(urlPath, route), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		current = path.Join(current, name) + "/"
+		crumbs = append(crumbs, dirListingCrumb{Name: name, URL: (&url.URL{Path: current}).String()})
+	}
+	return crumbs
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDirListingCrumbs(t *testing.T) {
+	got := dirListingCrumbs("/files/", "/files/docs/2024/")
+	want := []dirListingCrumb{
+		{Name: "/", URL: "/files/"},
+		{Name: "docs", URL: "/files/docs/"},
+		{Name: "2024", URL: "/files/docs/2024/"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("dirListingCrumbs: got %v, want %v", got, want)
+	}
+}
+
+func TestDirListingNotModified(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(file, []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(file, past, past)
+	os.Chtimes(dir, past, past)
+
+	a := &asset{Route: "/files/", Path: dir, Flags: HAFDirListing}
+	list := func(header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/files/", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		fi, _ := os.Stat(dir)
+		a.dirListing(w, r, dir, fi.ModTime(), true)
+		return w
+	}
+
+	first := list("", "")
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+	if first.Code != http.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("first listing: code %v, etag %q, last modified %q", first.Code, etag, lastModified)
+	}
+	if w := list("If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Errorf("unchanged listing with If-None-Match: got %v", w.Code)
+	}
+	if w := list("If-Modified-Since", lastModified); w.Code != http.StatusNotModified {
+		t.Errorf("unchanged listing with If-Modified-Since: got %v", w.Code)
+	}
+
+	// rewriting the file does not change the directory modification time
+	if err := os.WriteFile(file, []byte("three"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(dir, past, past)
+	if w := list("If-None-Match", etag); w.Code != http.StatusOK {
+		t.Errorf("changed listing with If-None-Match: got %v", w.Code)
+	}
+	if w := list("If-Modified-Since", lastModified); w.Code != http.StatusOK {
+		t.Errorf("changed listing with If-Modified-Since: got %v", w.Code)
+	}
+}
+
+func TestWriteThumbnailConcurrent(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), ".thumbnails", "image.jpg")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writeThumbnail(cachePath, []byte("thumbnail")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if data, err := os.ReadFile(cachePath); err != nil || string(data) != "thumbnail" {
+		t.Errorf("thumbnail: got %q, %v", data, err)
+	}
+	files, _ := os.ReadDir(filepath.Dir(cachePath))
+	if len(files) != 1 {
+		t.Errorf("thumbnail directory has %v files, want 1", len(files))
+	}
+}
+
+func TestDirListingRepresentations(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("one"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &asset{Route: "/files/", Path: dir, Flags: HAFDirListing}
+	list := func(accept, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/files/", nil)
+		r.Header.Set("Accept", accept)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		w.Header().Set("Vary", "Accept-Encoding")
+		fi, _ := os.Stat(dir)
+		a.dirListing(w, r, dir, fi.ModTime(), true)
+		return w
+	}
+
+	html, json := list("text/html", ""), list("application/json", "")
+	if html.Header().Get("ETag") == json.Header().Get("ETag") {
+		t.Errorf("HTML and JSON listings have the same ETag %v", html.Header().Get("ETag"))
+	}
+	if vary := html.Header().Values("Vary"); !reflect.DeepEqual(vary, []string{"Accept-Encoding", "Accept"}) {
+		t.Errorf("Vary: got %q", vary)
+	}
+	if w := list("application/json", html.Header().Get("ETag")); w.Code != http.StatusOK {
+		t.Errorf("JSON listing with the ETag of HTML listing: got %v", w.Code)
+	}
+	if w := list("application/json", json.Header().Get("ETag")); w.Code != http.StatusNotModified {
+		t.Errorf("JSON listing with its ETag: got %v", w.Code)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestAssetCacheControl(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		maxAge       string
+		immutable    bool
+		header       string
+		fail         bool
+	}{
+		{"", "", false, "", false},
+		{"no-store", "3600", true, "no-store", false},
+		{"", "3600", false, "max-age=3600", false},
+		{"", "365d", true, "max-age=31536000, immutable", false},
+		{"", "-1", false, "", true},
+		{"", "", true, "", true},
+	}
+	for _, test := range tests {
+		a := &asset{Route: "/a/", CacheControl: test.cacheControl, MaxAge: test.maxAge, Immutable: test.immutable}
+		err := a.validCacheControl()
+		if (err != nil) != test.fail {
+			t.Errorf("cacheControl %q, maxAge %q, immutable %v: unexpected error %v", test.cacheControl, test.maxAge, test.immutable, err)
+		} else if !test.fail && a.cacheControl != test.header {
+			t.Errorf("cacheControl %q, maxAge %q, immutable %v: got %q, want %q", test.cacheControl, test.maxAge, test.immutable, a.cacheControl, test.header)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const assetThumbnailDefaultSize = 256
+const assetThumbnailDefaultDir = ".thumbnails"
+const assetThumbnailMaxPixels = 64 << 20
+
+// thumbnails are generated one at a time to limit memory usage by decoded images
+var assetThumbnailLock sync.Mutex
+
+func thumbnailSupported(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif":
+		return true
+	}
+	return false
+}
+
+func (a *asset) validThumbnails() error {
+	a.thumbnailSize = assetThumbnailDefaultSize
+	if a.ThumbnailSize < 0 {
+		return fmt.Errorf("the route '%s' has negative thumbnailSize", a.Route)
+	} else if a.ThumbnailSize > 0 {
+		a.thumbnailSize = a.ThumbnailSize
+	}
+	a.thumbnailDir = a.ThumbnailDir
+	if a.thumbnailDir == "" {
+		a.thumbnailDir = filepath.Join(a.Path, assetThumbnailDefaultDir)
+	}
+	return nil
+}
+
+// thumbnail serves the thumbnail of the image file, generated thumbnails are cached in the thumbnails directory
+func (a *asset) thumbnail(w http.ResponseWriter, r *http.Request, trgPath string, fi os.FileInfo) {
+	if !thumbnailSupported(trgPath) {
+		http.NotFound(w, r)
+		return
+	}
+
+	hash := sha256.Sum256([]byte(trgPath))
+	cachePath := filepath.Join(a.thumbnailDir, hex.EncodeToString(hash[:16])+"-"+strconv.Itoa(a.thumbnailSize)+".jpg")
+
+	if cfi, err := os.Stat(cachePath); err == nil && !cfi.ModTime().Before(fi.ModTime()) {
+		if f, err := os.Open(cachePath); err == nil {
+			defer f.Close()
+			w.Header().Set("Content-Type", "image/jpeg")
+			http.ServeContent(w, r, cachePath, cfi.ModTime(), f)
+			return
+		}
+	}
+
+	data, err := a.generateThumbnail(trgPath)
+	if err != nil {
+		httpSetLogBulkData(r, logData{
+			"asset": {"thumbnailError": err.Error()},
+		})
+		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		return
+	}
+
+	modTime := time.Now()
+	if err := writeThumbnail(cachePath, data); err != nil {
+		// serve the thumbnail anyway, the directory could be read-only
+		httpSetLogBulkData(r, logData{
+			"asset": {"thumbnailError": err.Error()},
+		})
+		modTime = fi.ModTime()
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, cachePath, modTime, bytes.NewReader(data))
+}
+
+func (a *asset) generateThumbnail(trgPath string) ([]byte, error) {
+	assetThumbnailLock.Lock()
+	defer assetThumbnailLock.Unlock()
+
+	f, err := os.Open(trgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > assetThumbnailMaxPixels {
+		return nil, fmt.Errorf("unsupported image dimensions %vx%v", cfg.Width, cfg.Height)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleImage(src, a.thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeThumbnail(cachePath string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err != nil {
+		return err
+	}
+	// the unique temporary file keeps the concurrent writers of the same thumbnail apart
+	f, err := os.CreateTemp(filepath.Dir(cachePath), filepath.Base(cachePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, cachePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+	return err
+}
+
+// scaleImage fits the image into the square of the given size averaging the source pixels
+func scaleImage(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if sw > size || sh > size {
+		if sw >= sh {
+			dw, dh = size, max(1, sh*size/sw)
+		} else {
+			dw, dh = max(1, sw*size/sh), size
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := b.Min.Y+y*sh/dh, b.Min.Y+max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := b.Min.X+x*sw/dw, b.Min.X+max((x+1)*sw/dw, x*sw/dw+1)
+			// sample at most 4x4 pixels of the source block
+			stepX, stepY := max(1, (x1-x0)/4), max(1, (y1-y0)/4)
+			var rs, gs, bs, as, n uint64
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					r, g, b, a := src.At(sx, sy).RGBA()
+					rs, gs, bs, as = rs+uint64(r), gs+uint64(g), bs+uint64(b), as+uint64(a)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(rs / n >> 8), G: uint8(gs / n >> 8), B: uint8(bs / n >> 8), A: uint8(as / n >> 8)})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	iofs "io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/webdav"
+	"golang.org/x/time/rate"
+)
+
+var webdavWriteMethods = map[string]struct{}{
+	"PUT":       {},
+	"DELETE":    {},
+	"MKCOL":     {},
+	"COPY":      {},
+	"MOVE":      {},
+	"PROPPATCH": {},
+	"LOCK":      {},
+	"UNLOCK":    {},
+}
+
+// webdavBasicFailures limits the failed password checks of all WebDAV routes like the rate limit of the login route
+var webdavBasicFailures = rate.NewLimiter(5, 2)
+
+// assetFileSystem exposes asset directory to WebDAV handler hiding the files which are not visible through the asset
+type assetFileSystem struct {
+	a  *asset
+	fs webdav.FileSystem
+}
+
+type assetFile struct {
+	webdav.File
+	fs   *assetFileSystem
+	name string
+}
+
+func (a *asset) newWebDAVHandler() http.Handler {
+	return &webdav.Handler{
+		Prefix:     strings.TrimSuffix(a.Route, "/"),
+		FileSystem: &assetFileSystem{a: a, fs: webdav.Dir(a.Path)},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				httpSetLogBulkData(r, logData{
+					"webdav": {"error": err.Error()},
+				})
+			}
+		},
+	}
+}
+
+// visible checks the path and every directory on the way, the content of hidden or excluded directory is not visible;
+// includes apply to the files only
+func (fs *assetFileSystem) visible(name string, dir bool) bool {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return true
+	}
+	parts := strings.Split(name[1:], "/")
+	trgPath := fs.a.Path
+	for i, part := range parts {
+		trgPath = filepath.Join(trgPath, part)
+		if i < len(parts)-1 || dir {
+			if !fs.a.checkDirVisibility(trgPath) {
+				return false
+			}
+		} else if !fs.a.checkVisibility(trgPath) {
+			return false
+		}
+	}
+	return true
+}
+
+// visibleEntry checks the path as the directory if it is the existing directory, otherwise as the file
+func (fs *assetFileSystem) visibleEntry(name string) bool {
+	return fs.visible(name, fs.isDir(name))
+}
+
+func (fs *assetFileSystem) isDir(name string) bool {
+	fi, err := os.Stat(filepath.Join(fs.a.Path, filepath.FromSlash(path.Clean("/"+name))))
+	return err == nil && fi.IsDir()
+}
+
+// hasInvisible reports if the directory holds the entries which are not visible, at any depth
+func (fs *assetFileSystem) hasInvisible(name string) bool {
+	name = path.Clean("/" + name)
+	root := filepath.Join(fs.a.Path, filepath.FromSlash(name))
+	found := false
+	filepath.WalkDir(root, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			found = true
+			return filepath.SkipAll
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil || !fs.visible(path.Join(name, filepath.ToSlash(rel)), d.IsDir()) {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+func (fs *assetFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if !fs.visible(name, true) {
+		return os.ErrPermission
+	}
+	return fs.fs.Mkdir(ctx, name, perm)
+}
+
+func (fs *assetFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if !fs.visibleEntry(name) {
+		if (flag & os.O_CREATE) != 0 {
+			return nil, os.ErrPermission
+		}
+		return nil, os.ErrNotExist
+	}
+	f, err := fs.fs.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &assetFile{File: f, fs: fs, name: name}, nil
+}
+
+func (fs *assetFileSystem) RemoveAll(ctx context.Context, name string) error {
+	dir := fs.isDir(name)
+	if !fs.visible(name, dir) {
+		return os.ErrNotExist
+	}
+	// the hidden and excluded files are not removed with the visible directory
+	if dir && fs.hasInvisible(name) {
+		return os.ErrPermission
+	}
+	return fs.fs.RemoveAll(ctx, name)
+}
+
+func (fs *assetFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	dir := fs.isDir(oldName)
+	if !fs.visible(oldName, dir) {
+		return os.ErrNotExist
+	}
+	if !fs.visible(newName, dir) {
+		return os.ErrPermission
+	}
+	return fs.fs.Rename(ctx, oldName, newName)
+}
+
+func (fs *assetFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if !fs.visibleEntry(name) {
+		return nil, os.ErrNotExist
+	}
+	return fs.fs.Stat(ctx, name)
+}
+
+func (f *assetFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	rv := fis[:0]
+	for _, fi := range fis {
+		if f.fs.visible(path.Join(f.name, fi.Name()), fi.IsDir()) {
+			rv = append(rv, fi)
+		}
+	}
+	return rv, err
+}
+
+// webdavAuthorize accepts either hogate access token or basic authentication used by the most of WebDAV clients
+func webdavAuthorize(w http.ResponseWriter, r *http.Request, scope []string) bool {
+	if userName, password, ok := r.BasicAuth(); ok {
+		if webdavBasicFailures.Tokens() < 1 {
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return true
+		}
+		if ui, ok := credentials.verifyUser(userName, password); ok && ui.scope.test(newScopeSet(scope...), true) {
+			httpSetLogBulkData(r, logData{
+				"auth": {"u": ui.name},
+			})
+			return false
+		}
+		webdavBasicFailures.Allow()
+	} else if status, _ := testAuthorization(r, scope...); status == http.StatusOK {
+		return false
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="hogate", charset="UTF-8"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	return true
+}
+
+// serveWebDAV handles WebDAV requests; regular asset serving handles GET, HEAD and POST requests
+func (a *asset) serveWebDAV(w http.ResponseWriter, r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "POST":
+		return false
+	}
+
+	if _, ok := webdavWriteMethods[r.Method]; ok {
+		// modifications always require authorization
+		if webdavAuthorize(w, r, a.parsedWriteScope) {
+			return true
+		}
+	} else if (a.Flags&(HAFAuthenticate|HAFAuthorize)) != 0 && webdavAuthorize(w, r, a.parsedScope) {
+		return true
+	}
+
+	a.webdavHandler.ServeHTTP(w, r)
+	return true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+	"golang.org/x/time/rate"
+)
+
+func TestAssetFileSystemVisible(t *testing.T) {
+	tests := []struct {
+		flags    HttpAssetFlag
+		includes []string
+		excludes []string
+		name     string
+		dir      bool
+		visible  bool
+	}{
+		{0, nil, nil, "/", false, true},
+		{0, nil, nil, "docs/file.txt", false, true},
+		{0, nil, nil, ".thumbnails/x", false, false},
+		{0, nil, nil, ".git/config", false, false},
+		{0, nil, nil, "docs/.hidden/file.txt", false, false},
+		{HAFShowHidden, nil, nil, ".git/config", false, true},
+		{0, nil, []string{"private"}, "private/file.txt", false, false},
+		{0, nil, []string{"private"}, "docs/private/file.txt", false, false},
+		{0, []string{"*.txt"}, nil, "docs/file.txt", false, true},
+		{0, []string{"*.txt"}, nil, "docs/file.jpg", false, false},
+		{0, []string{"*.txt"}, nil, "docs", true, true},
+		{0, []string{"*.txt"}, nil, "docs/sub", true, true},
+		{0, nil, []string{"private"}, "private", true, false},
+		{0, nil, nil, ".git", true, false},
+	}
+	for _, test := range tests {
+		a := &asset{Path: "/srv/assets", Flags: test.flags, Includes: test.includes, Excludes: test.excludes}
+		fs := &assetFileSystem{a: a}
+		if v := fs.visible(test.name, test.dir); v != test.visible {
+			t.Errorf("visible(%q, %v) with flags %v, includes %v, excludes %v: got %v, want %v", test.name, test.dir, test.flags, test.includes, test.excludes, v, test.visible)
+		}
+	}
+}
+
+func TestAssetFileSystemModify(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"photos/a.jpg", "photos/.hidden/b.jpg", "notes/a.jpg", "notes/c.txt"} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs := &assetFileSystem{a: &asset{Path: dir, Includes: []string{"*.jpg"}}, fs: webdav.Dir(dir)}
+	ctx := context.Background()
+
+	if err := fs.Mkdir(ctx, "albums", 0755); err != nil {
+		t.Errorf("mkdir albums: %v", err)
+	}
+	if err := fs.Mkdir(ctx, ".albums", 0755); err == nil {
+		t.Errorf("mkdir .albums: hidden directory is created")
+	}
+	if _, err := fs.Stat(ctx, "albums"); err != nil {
+		t.Errorf("stat albums: %v", err)
+	}
+	if err := fs.RemoveAll(ctx, "photos"); err == nil {
+		t.Errorf("remove photos: the directory with hidden entries is removed")
+	}
+	if err := fs.RemoveAll(ctx, "notes"); err == nil {
+		t.Errorf("remove notes: the directory with not included files is removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes", "c.txt")); err != nil {
+		t.Errorf("notes/c.txt: %v", err)
+	}
+	if err := fs.RemoveAll(ctx, "notes/a.jpg"); err != nil {
+		t.Errorf("remove notes/a.jpg: %v", err)
+	}
+	if err := fs.RemoveAll(ctx, "albums"); err != nil {
+		t.Errorf("remove albums: %v", err)
+	}
+}
+
+func TestAssetWebDAVWriteScope(t *testing.T) {
+	a := &asset{Route: "/files/", Path: t.TempDir(), Flags: HAFWebDAV}
+	if err := a.valid(map[string]struct{}{}); err == nil {
+		t.Errorf("webdav asset without writeScope is valid")
+	}
+	a.WriteScope = "files"
+	if err := a.valid(map[string]struct{}{}); err != nil {
+		t.Errorf("webdav asset with writeScope: %v", err)
+	}
+}
+
+func TestWebDAVAuthorizeFailures(t *testing.T) {
+	savedUsers, savedFailures := credentials.users, webdavBasicFailures
+	defer func() { credentials.users, webdavBasicFailures = savedUsers, savedFailures }()
+	credentials.users = map[string]userInfo{"bob": {name: "bob", password: "secret", scope: newScopeSet("files")}}
+	webdavBasicFailures = rate.NewLimiter(rate.Every(time.Hour), 2)
+
+	authorize := func(password string) int {
+		r := httptest.NewRequest("PROPFIND", "/files/", nil)
+		r.SetBasicAuth("bob", password)
+		w := httptest.NewRecorder()
+		if webdavAuthorize(w, r, []string{"files"}) {
+			return w.Code
+		}
+		return http.StatusOK
+	}
+
+	tests := []struct {
+		password string
+		code     int
+	}{
+		{"secret", http.StatusOK},
+		{"secret", http.StatusOK},
+		{"guess1", http.StatusUnauthorized},
+		{"guess2", http.StatusUnauthorized},
+		{"guess3", http.StatusTooManyRequests},
+		{"secret", http.StatusTooManyRequests},
+	}
+	for i, test := range tests {
+		if code := authorize(test.password); code != test.code {
+			t.Errorf("attempt %v with password %q: got %v, want %v", i+1, test.password, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+)
+
+const assetZipDefaultMaxSize = 4 << 30
+const assetZipDefaultMaxFiles = 10000
+
+func (a *asset) validZipLimits() error {
+	a.zipMaxSize = assetZipDefaultMaxSize
+	a.zipMaxFiles = assetZipDefaultMaxFiles
+	if a.ZipMaxSize != "" {
+		size, err := parseSizeString(a.ZipMaxSize)
+		if err == nil && size <= 0 {
+			err = fmt.Errorf("positive value expected")
+		}
+		if err != nil {
+			return fmt.Errorf("the route '%s' has invalid zipMaxSize '%s': %v", a.Route, a.ZipMaxSize, err)
+		}
+		a.zipMaxSize = size
+	}
+	if a.ZipMaxFiles < 0 {
+		return fmt.Errorf("the route '%s' has negative zipMaxFiles", a.Route)
+	} else if a.ZipMaxFiles > 0 {
+		a.zipMaxFiles = a.ZipMaxFiles
+	}
+	return nil
+}
+
+// zipDownload streams visible content of the directory as ZIP archive
+func (a *asset) zipDownload(w http.ResponseWriter, r *http.Request, trgPath string) {
+	var files []fileToArchive
+	var size int64
+	errLimit := fmt.Errorf("limit exceeded")
+	err := filepath.WalkDir(trgPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == trgPath {
+			return nil
+		}
+		if !a.checkVisibility(filePath) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(trgPath, filePath)
+		if err != nil {
+			return err
+		}
+		size += fi.Size()
+		files = append(files, fileToArchive{name: filepath.ToSlash(name), path: filePath})
+		if size > a.zipMaxSize || len(files) > a.zipMaxFiles {
+			return errLimit
+		}
+		return nil
+	})
+	if err == errLimit {
+		httpSetLogBulkData(r, logData{
+			"asset": {"zipFiles": strconv.Itoa(len(files)), "zipSize": strconv.FormatInt(size, 10), "error": "zip limit exceeded"},
+		})
+		http.Error(w, "The directory is too large to download as an archive.", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		httpSetLogBulkData(r, logData{
+			"asset": {"error": err.Error()},
+		})
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	httpSetLogBulkData(r, logData{
+		"asset": {"zipFiles": strconv.Itoa(len(files)), "zipSize": strconv.FormatInt(size, 10)},
+	})
+
+	name := filepath.Base(trgPath)
+	if name == "." || name == string(filepath.Separator) {
+		name = "archive"
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s", url.PathEscape(name+".zip")))
+	if r.Method == "HEAD" {
+		return
+	}
+
+	zw := zip.NewWriter(w)
+	if err := zipFilesToWriter(zw, files); err != nil {
+		// the response is already started, so just log the error and abort the archive
+		httpSetLogBulkData(r, logData{
+			"asset": {"error": err.Error()},
+		})
+		return
+	}
+	zw.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -215,3 +216,30 @@ func authorizationHandler(scope ...string) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// authorizeRequest tests the request authorization and, if it fails, responds either with
+// the login page redirect or with the unauthorized status. Returns true if the response is written.
+func authorizeRequest(w http.ResponseWriter, r *http.Request, loginRedirect bool, scope []string) bool {
+	status, _ := testAuthorization(r, scope...)
+	if status == http.StatusOK {
+		return false
+	}
+
+	if loginRedirect {
+		targetURL := fmt.Sprintf(
+			"%s?redirect_uri=%s",
+			dedicatedRoutes[routeLogin].path, url.QueryEscape(r.URL.String()),
+		)
+		if len(scope) > 0 {
+			targetURL = fmt.Sprintf(
+				"%s&scope=%s",
+				targetURL, url.QueryEscape(strings.Join(scope, ",")),
+			)
+		}
+		w.Header().Set("Location", targetURL)
+		w.WriteHeader(http.StatusFound)
+	} else {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	}
+	return true
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressionDefaultMinSize = 1024
+
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+type compressionEncoder struct {
+	minLevel     int
+	maxLevel     int
+	defaultLevel int
+	newWriter    func(w io.Writer, level int) (compressWriter, error)
+}
+
+// supported encodings in the default order of preference
+var compressionEncodingOrder = []string{"br", "zstd", "gzip"}
+
+var compressionEncoders = map[string]compressionEncoder{
+	"br": {
+		minLevel:     brotli.BestSpeed,
+		maxLevel:     brotli.BestCompression,
+		defaultLevel: 5,
+		newWriter: func(w io.Writer, level int) (compressWriter, error) {
+			return brotli.NewWriterLevel(w, level), nil
+		},
+	},
+	"zstd": {
+		minLevel:     1,
+		maxLevel:     22,
+		defaultLevel: 3,
+		newWriter: func(w io.Writer, level int) (compressWriter, error) {
+			return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+		},
+	},
+	"gzip": {
+		minLevel:     gzip.HuffmanOnly,
+		maxLevel:     gzip.BestCompression,
+		defaultLevel: gzip.DefaultCompression,
+		newWriter: func(w io.Writer, level int) (compressWriter, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+	},
+}
+
+type compressionOptions struct {
+	encodings    []string
+	levels       map[string]int
+	minSize      int64
+	contentTypes []string
+	includes     []string
+	excludes     []string
+}
+
+func newCompressionOptions() *compressionOptions {
+	co := &compressionOptions{
+		encodings: compressionEncodingOrder,
+		levels:    make(map[string]int, len(compressionEncoders)),
+		minSize:   compressionDefaultMinSize,
+	}
+	for name, enc := range compressionEncoders {
+		co.levels[name] = enc.defaultLevel
+	}
+	return co
+}
+
+func parseCompression(c *Compression) (*compressionOptions, error) {
+	co := newCompressionOptions()
+
+	if c.Encodings != "" {
+		co.encodings = nil
+		var err error
+		parseOptions(c.Encodings, func(encoding string) bool {
+			if _, ok := compressionEncoders[encoding]; !ok {
+				err = fmt.Errorf("unknown encoding '%v'", encoding)
+				return false
+			}
+			co.encodings = append(co.encodings, encoding)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, level := range c.Levels {
+		enc, ok := compressionEncoders[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown encoding '%v' in levels", name)
+		}
+		if level < enc.minLevel || level > enc.maxLevel {
+			return nil, fmt.Errorf("%v level %v is out of range %v..%v", name, level, enc.minLevel, enc.maxLevel)
+		}
+		co.levels[name] = level
+	}
+
+	if c.MinSize != "" {
+		minSize, err := parseSizeString(c.MinSize)
+		if err == nil && minSize < 0 {
+			err = fmt.Errorf("negative value not allowed")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid minSize value '%v': %v", c.MinSize, err)
+		}
+		co.minSize = minSize
+	}
+
+	for _, pattern := range c.ContentTypes {
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return nil, fmt.Errorf("invalid content type pattern '%v'", pattern)
+		}
+		co.contentTypes = append(co.contentTypes, strings.ToLower(pattern))
+	}
+	for _, pattern := range append(append([]string{}, c.Includes...), c.Excludes...) {
+		if _, err := path.Match(pattern, "/"); err != nil {
+			return nil, fmt.Errorf("invalid path pattern '%v'", pattern)
+		}
+	}
+	co.includes = c.Includes
+	co.excludes = c.Excludes
+
+	return co, nil
+}
+
+// negotiate selects the encoding with the highest q-value, the server preference breaks ties
+func (co *compressionOptions) negotiate(r *http.Request) string {
+	accepted := parseAcceptEncoding(r)
+	encoding, best := "", 0.0
+	for _, name := range co.encodings {
+		q, ok := accepted[name]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > best {
+			encoding, best = name, q
+		}
+	}
+	return encoding
+}
+
+func (co *compressionOptions) skipPath(r *http.Request) bool {
+	return skipByPatterns(
+		co.includes, co.excludes,
+		[]string{r.URL.Path, path.Base(r.URL.Path)},
+		func(pattern, value string) bool {
+			m, err := path.Match(pattern, value)
+			return m && err == nil
+		},
+	)
+}
+
+func (co *compressionOptions) compressibleType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	if len(co.contentTypes) == 0 {
+		return !compressedMediaType(mediaType)
+	}
+	for _, pattern := range co.contentTypes {
+		if m, err := path.Match(pattern, mediaType); m && err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// compressedMediaType reports whether the content of the media type is already compressed
+func compressedMediaType(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch mediaType {
+	case "image/svg+xml", "image/x-icon", "image/bmp", "audio/wav", "audio/x-wav":
+		return false
+	case "application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed",
+		"application/x-rar-compressed", "application/x-bzip2", "application/x-xz", "application/zstd",
+		"font/woff", "font/woff2":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "image/") || strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/")
+}
+
+// addVary adds the header name to Vary response header unless it is already listed
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+// compressResponseWriter buffers the beginning of the response until it's known whether the
+// response is worth compressing: the status and the headers are sent once the decision is made
+type compressResponseWriter struct {
+	http.ResponseWriter
+	options  *compressionOptions
+	encoding string
+	status   int
+	decided  bool
+	buffer   []byte
+	writer   compressWriter
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status) // informational responses pass through
+		return
+	}
+	w.status = status
+
+	header := w.ResponseWriter.Header()
+	if header.Get("Content-Encoding") != "" || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent || !w.options.compressibleType(header.Get("Content-Type")) {
+		w.decide(false)
+		return
+	}
+	if cl := header.Get("Content-Length"); cl != "" {
+		if size, err := strconv.ParseInt(cl, 10, 64); err == nil {
+			w.decide(size >= w.options.minSize)
+		}
+	}
+}
+
+func (w *compressResponseWriter) decide(compress bool) {
+	w.decided = true
+	if compress {
+		if cw, err := compressionEncoders[w.encoding].newWriter(w.ResponseWriter, w.options.levels[w.encoding]); err == nil {
+			header := w.ResponseWriter.Header()
+			header.Del("Content-Length")
+			header.Del("Accept-Ranges")
+			header.Set("Content-Encoding", w.encoding)
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", "W/"+etag)
+			}
+			w.writer = cw
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buffer) > 0 {
+		buffer := w.buffer
+		w.buffer = nil
+		w.write(buffer)
+	}
+}
+
+func (w *compressResponseWriter) write(b []byte) (int, error) {
+	if w.writer != nil {
+		return w.writer.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.decided {
+		return w.write(b)
+	}
+	w.buffer = append(w.buffer, b...)
+	if int64(len(w.buffer)) >= w.options.minSize {
+		w.decide(true)
+	}
+	return len(b), nil
+}
+
+func (w *compressResponseWriter) Flush() {
+	if !w.decided && w.status != 0 {
+		w.decide(true) // streaming response
+	}
+	if w.writer != nil {
+		w.writer.Flush()
+	}
+	if fw, ok := w.ResponseWriter.(http.Flusher); ok {
+		fw.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Close() error {
+	if !w.decided && w.status != 0 {
+		w.decide(false) // the response is smaller than the threshold
+	}
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+	return nil
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func compressionHandler(options *compressionOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if options.skipPath(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			addVary(w.Header(), "Accept-Encoding")
+
+			// ranges are served from the identity content, including the full response when If-Range does not match
+			encoding := options.negotiate(r)
+			if encoding == "" || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressResponseWriter{ResponseWriter: w, options: options, encoding: encoding}
+			defer cw.Close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,6 +20,8 @@ type Config struct {
 	HTTPServer        HTTPServerConfig  `yaml:"httpServer"`
 	Routes            *[]Route          `yaml:"routes"`
 	Assets            []*HTTPAsset      `yaml:"assets,omitempty"`
+	Proxies           []*HTTPProxy      `yaml:"proxies,omitempty"`
+	Redirects         []HTTPRedirect    `yaml:"redirects,omitempty"`
 	Scopes            map[string]string `yaml:"scopes,omitempty"`
 	Login             *LoginConfig      `yaml:"login,omitempty"`
 	*Authorization    `yaml:"authorization"`
@@ -38,6 +41,7 @@ type HTTPServerConfig struct {
 	WriteTimeout      uint           `yaml:"writeTimeout,omitempty"`      // milliseconds
 	IdleTimeout       uint           `yaml:"idleTimeout,omitempty"`       // milliseconds
 	MaxHeaderBytes    uint32         `yaml:"maxHeaderBytes,omitempty"`
+	TrustedProxies    []string       `yaml:"trustedProxies,omitempty"` // addresses or CIDRs of proxies allowed to set X-Forwarded-For/X-Real-IP
 	Log               *HTTPServerLog `yaml:"log,omitempty"`
 	*TLSFiles         `yaml:"tlsFiles,omitempty"`
 	*TLSAcme          `yaml:"tlsAcme,omitempty"`
@@ -81,7 +85,33 @@ type RouteProperties interface {
 	PropOriginIncludes() []string
 	PropOriginExcludes() []string
 	PropHeaders() string
+	PropExposeHeaders() string
+	PropCorsMaxAge() string
 	PropAllowCredentials() bool
+	PropAllowCidrs() []string
+	PropDenyCidrs() []string
+	PropResponseHeaders() *ResponseHeaders
+	PropCompression() *Compression
+}
+
+// ResponseHeaders struct
+type ResponseHeaders struct {
+	HSTS                  string            `yaml:"hsts,omitempty"` // max age, optionally followed by includeSubDomains and/or preload; sent over TLS only
+	ContentSecurityPolicy string            `yaml:"contentSecurityPolicy,omitempty"`
+	FrameOptions          string            `yaml:"frameOptions,omitempty"`
+	ReferrerPolicy        string            `yaml:"referrerPolicy,omitempty"`
+	PermissionsPolicy     string            `yaml:"permissionsPolicy,omitempty"`
+	Extra                 map[string]string `yaml:"extra,omitempty"` // arbitrary headers, empty value removes the header
+}
+
+// Compression struct, the response compression is enabled when the section is present
+type Compression struct {
+	Encodings    string         `yaml:"encodings,omitempty"`    // br, zstd, gzip in the order of preference; default is "br,zstd,gzip"
+	Levels       map[string]int `yaml:"levels,omitempty"`       // compression level per encoding
+	MinSize      string         `yaml:"minSize,omitempty"`      // smaller responses are not compressed, default is 1k
+	ContentTypes []string       `yaml:"contentTypes,omitempty"` // media type patterns, like text/*; default is any not compressed media type
+	Includes     []string       `yaml:"includes,omitempty"`     // request path patterns
+	Excludes     []string       `yaml:"excludes,omitempty"`
 }
 
 // Route struct
@@ -95,7 +125,14 @@ type Route struct {
 	OriginIncludes   []string `yaml:"originIncludes,omitempty"`
 	OriginExcludes   []string `yaml:"originExcludes,omitempty"`
 	Headers          string   `yaml:"headers,omitempty"`
+	ExposeHeaders    string   `yaml:"exposeHeaders,omitempty"`
+	CorsMaxAge       string   `yaml:"corsMaxAge,omitempty"`
 	AllowCredentials bool     `yaml:"allowCredentials,omitempty"`
+	AllowCidrs       []string `yaml:"allowCidrs,omitempty"`
+	DenyCidrs        []string `yaml:"denyCidrs,omitempty"`
+
+	ResponseHeaders *ResponseHeaders `yaml:"responseHeaders,omitempty"`
+	Compression     *Compression     `yaml:"compression,omitempty"`
 }
 
 func (r *Route) PropRateLimit() string {
@@ -122,22 +159,54 @@ func (r *Route) PropHeaders() string {
 	return r.Headers
 }
 
+func (r *Route) PropExposeHeaders() string {
+	return r.ExposeHeaders
+}
+
+func (r *Route) PropCorsMaxAge() string {
+	return r.CorsMaxAge
+}
+
 func (r *Route) PropAllowCredentials() bool {
 	return r.AllowCredentials
 }
 
+func (r *Route) PropAllowCidrs() []string {
+	return r.AllowCidrs
+}
+
+func (r *Route) PropDenyCidrs() []string {
+	return r.DenyCidrs
+}
+
+func (r *Route) PropResponseHeaders() *ResponseHeaders {
+	return r.ResponseHeaders
+}
+
+func (r *Route) PropCompression() *Compression {
+	return r.Compression
+}
+
 type HTTPAsset struct {
 	routeBase
 
-	Route        string        `yaml:"route,omitempty"`
-	Path         string        `yaml:"path,omitempty"`
-	IndexFiles   []string      `yaml:"indexFiles,omitempty"`
-	Includes     []string      `yaml:"includes,omitempty"`
-	Excludes     []string      `yaml:"excludes,omitempty"`
-	GzipIncludes []string      `yaml:"gzipIncludes,omitempty"`
-	GzipExcludes []string      `yaml:"gzipExcludes,omitempty"`
-	Flags        HttpAssetFlag `yaml:"flags,omitempty"`
-	Scope        string        `yaml:"scope,omitempty"`
+	Route         string        `yaml:"route,omitempty"`
+	Path          string        `yaml:"path,omitempty"`
+	IndexFiles    []string      `yaml:"indexFiles,omitempty"`
+	Includes      []string      `yaml:"includes,omitempty"`
+	Excludes      []string      `yaml:"excludes,omitempty"`
+	GzipIncludes  []string      `yaml:"gzipIncludes,omitempty"`
+	GzipExcludes  []string      `yaml:"gzipExcludes,omitempty"`
+	Flags         HttpAssetFlag `yaml:"flags,omitempty"`
+	Scope         string        `yaml:"scope,omitempty"`
+	WriteScope    string        `yaml:"writeScope,omitempty"`    // scope required to modify files with WebDAV, mandatory for webdav assets
+	ZipMaxSize    string        `yaml:"zipMaxSize,omitempty"`    // maximal total size of files downloaded as ZIP archive, default is 4G
+	ZipMaxFiles   int           `yaml:"zipMaxFiles,omitempty"`   // maximal number of files downloaded as ZIP archive, default is 10000
+	ThumbnailDir  string        `yaml:"thumbnailDir,omitempty"`  // thumbnails cache directory, default is .thumbnails in the asset directory
+	ThumbnailSize int           `yaml:"thumbnailSize,omitempty"` // thumbnail bounding box size in pixels, default is 256
+	MaxAge        string        `yaml:"maxAge,omitempty"`        // Cache-Control max-age for the files
+	Immutable     bool          `yaml:"immutable,omitempty"`     // adds immutable to Cache-Control, for the files with content hash in the name
+	CacheControl  string        `yaml:"cacheControl,omitempty"`  // Cache-Control value, overrides maxAge and immutable
 
 	RateLimit        string   `yaml:"rateLimit,omitempty"`
 	MaxBodySize      string   `yaml:"maxBodySize,omitempty"`
@@ -145,9 +214,23 @@ type HTTPAsset struct {
 	OriginIncludes   []string `yaml:"originIncludes,omitempty"`
 	OriginExcludes   []string `yaml:"originExcludes,omitempty"`
 	Headers          string   `yaml:"headers,omitempty"`
+	ExposeHeaders    string   `yaml:"exposeHeaders,omitempty"`
+	CorsMaxAge       string   `yaml:"corsMaxAge,omitempty"`
 	AllowCredentials bool     `yaml:"allowCredentials,omitempty"`
+	AllowCidrs       []string `yaml:"allowCidrs,omitempty"`
+	DenyCidrs        []string `yaml:"denyCidrs,omitempty"`
 
-	parsedScope []string
+	ResponseHeaders *ResponseHeaders `yaml:"responseHeaders,omitempty"`
+	Compression     *Compression     `yaml:"compression,omitempty"`
+
+	parsedScope      []string
+	parsedWriteScope []string
+	webdavHandler    http.Handler
+	zipMaxSize       int64
+	zipMaxFiles      int
+	thumbnailDir     string
+	thumbnailSize    int
+	cacheControl     string
 }
 
 func (a *HTTPAsset) PropRateLimit() string {
@@ -174,11 +257,35 @@ func (a *HTTPAsset) PropHeaders() string {
 	return a.Headers
 }
 
+func (a *HTTPAsset) PropExposeHeaders() string {
+	return a.ExposeHeaders
+}
+
+func (a *HTTPAsset) PropCorsMaxAge() string {
+	return a.CorsMaxAge
+}
+
 func (a *HTTPAsset) PropAllowCredentials() bool {
 	return a.AllowCredentials
 }
 
-type HttpAssetFlag byte
+func (a *HTTPAsset) PropAllowCidrs() []string {
+	return a.AllowCidrs
+}
+
+func (a *HTTPAsset) PropDenyCidrs() []string {
+	return a.DenyCidrs
+}
+
+func (a *HTTPAsset) PropResponseHeaders() *ResponseHeaders {
+	return a.ResponseHeaders
+}
+
+func (a *HTTPAsset) PropCompression() *Compression {
+	return a.Compression
+}
+
+type HttpAssetFlag uint32
 
 const (
 	HAFShowHidden = HttpAssetFlag(1 << iota)
@@ -187,15 +294,25 @@ const (
 	HAFFlat
 	HAFAuthenticate
 	HAFAuthorize
+	HAFPrecompressed
+	HAFETag
+	HAFWebDAV
+	HAFZipDownload
+	HAFThumbnails
 )
 
 var httpAssetFlagMap = map[string]HttpAssetFlag{
-	"show-hidden":  HAFShowHidden,
-	"dir-listing":  HAFDirListing,
-	"gzip":         HAFGZipContent,
-	"flat":         HAFFlat,
-	"authenticate": HAFAuthenticate,
-	"authorize":    HAFAuthorize,
+	"show-hidden":   HAFShowHidden,
+	"dir-listing":   HAFDirListing,
+	"gzip":          HAFGZipContent, // compression with default settings unless compression section is present
+	"flat":          HAFFlat,
+	"authenticate":  HAFAuthenticate,
+	"authorize":     HAFAuthorize,
+	"precompressed": HAFPrecompressed,
+	"etag":          HAFETag,
+	"webdav":        HAFWebDAV,
+	"zip-download":  HAFZipDownload,
+	"thumbnails":    HAFThumbnails,
 }
 
 func (flags *HttpAssetFlag) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
@@ -230,6 +347,164 @@ func (flags HttpAssetFlag) MarshalYAML() (interface{}, error) {
 	return flags.String(), nil
 }
 
+// HTTPRedirect struct
+type HTTPRedirect struct {
+	Match         string `yaml:"match,omitempty"` // exact (default), prefix or regex
+	From          string `yaml:"from"`
+	To            string `yaml:"to"`               // for regex match could contain $1, ${name} capture references
+	Status        int    `yaml:"status,omitempty"` // 301, 302 (default), 307 or 308
+	PreserveQuery bool   `yaml:"preserveQuery,omitempty"`
+	Rewrite       bool   `yaml:"rewrite,omitempty"` // serve the target path internally instead of redirecting
+}
+
+// HTTPProxy struct
+type HTTPProxy struct {
+	routeBase
+
+	Route           string                `yaml:"route,omitempty"`
+	Upstream        string                `yaml:"upstream,omitempty"` // http(s)://host[:port][/path]
+	Socket          string                `yaml:"socket,omitempty"`   // optional Unix socket path, upstream host is used for Host header only
+	Flags           HttpProxyFlag         `yaml:"flags,omitempty"`
+	Scope           string                `yaml:"scope,omitempty"`
+	RewriteHeaders  *HTTPProxyHeaders     `yaml:"rewriteHeaders,omitempty"`
+	DialTimeout     string                `yaml:"dialTimeout,omitempty"`
+	ResponseTimeout string                `yaml:"responseTimeout,omitempty"` // time to wait for upstream response headers
+	FlushInterval   string                `yaml:"flushInterval,omitempty"`
+	HealthCheck     *HTTPProxyHealthCheck `yaml:"healthCheck,omitempty"`
+
+	RateLimit        string   `yaml:"rateLimit,omitempty"`
+	MaxBodySize      string   `yaml:"maxBodySize,omitempty"`
+	Methods          string   `yaml:"methods,omitempty"`
+	OriginIncludes   []string `yaml:"originIncludes,omitempty"`
+	OriginExcludes   []string `yaml:"originExcludes,omitempty"`
+	Headers          string   `yaml:"headers,omitempty"`
+	ExposeHeaders    string   `yaml:"exposeHeaders,omitempty"`
+	CorsMaxAge       string   `yaml:"corsMaxAge,omitempty"`
+	AllowCredentials bool     `yaml:"allowCredentials,omitempty"`
+	AllowCidrs       []string `yaml:"allowCidrs,omitempty"`
+	DenyCidrs        []string `yaml:"denyCidrs,omitempty"`
+
+	ResponseHeaders *ResponseHeaders `yaml:"responseHeaders,omitempty"`
+	Compression     *Compression     `yaml:"compression,omitempty"`
+
+	parsedScope []string
+}
+
+// HTTPProxyHeaders struct, empty header value removes the header
+type HTTPProxyHeaders struct {
+	Request  map[string]string `yaml:"request,omitempty"`
+	Response map[string]string `yaml:"response,omitempty"`
+}
+
+// HTTPProxyHealthCheck struct
+type HTTPProxyHealthCheck struct {
+	Path     string `yaml:"path,omitempty"`
+	Interval string `yaml:"interval,omitempty"`
+	Timeout  string `yaml:"timeout,omitempty"`
+}
+
+func (p *HTTPProxy) PropRateLimit() string {
+	return p.RateLimit
+}
+
+func (p *HTTPProxy) PropMaxBodySize() string {
+	return p.MaxBodySize
+}
+
+func (p *HTTPProxy) PropMethods() string {
+	return p.Methods
+}
+
+func (p *HTTPProxy) PropOriginIncludes() []string {
+	return p.OriginIncludes
+}
+
+func (p *HTTPProxy) PropOriginExcludes() []string {
+	return p.OriginExcludes
+}
+
+func (p *HTTPProxy) PropHeaders() string {
+	return p.Headers
+}
+
+func (p *HTTPProxy) PropExposeHeaders() string {
+	return p.ExposeHeaders
+}
+
+func (p *HTTPProxy) PropCorsMaxAge() string {
+	return p.CorsMaxAge
+}
+
+func (p *HTTPProxy) PropAllowCredentials() bool {
+	return p.AllowCredentials
+}
+
+func (p *HTTPProxy) PropAllowCidrs() []string {
+	return p.AllowCidrs
+}
+
+func (p *HTTPProxy) PropDenyCidrs() []string {
+	return p.DenyCidrs
+}
+
+func (p *HTTPProxy) PropResponseHeaders() *ResponseHeaders {
+	return p.ResponseHeaders
+}
+
+func (p *HTTPProxy) PropCompression() *Compression {
+	return p.Compression
+}
+
+type HttpProxyFlag byte
+
+const (
+	HPFAuthenticate = HttpProxyFlag(1 << iota)
+	HPFAuthorize
+	HPFStripPrefix
+	HPFPreserveHost
+	HPFInsecureSkipVerify
+)
+
+var httpProxyFlagMap = map[string]HttpProxyFlag{
+	"authenticate":         HPFAuthenticate,
+	"authorize":            HPFAuthorize,
+	"strip-prefix":         HPFStripPrefix,
+	"preserve-host":        HPFPreserveHost,
+	"insecure-skip-verify": HPFInsecureSkipVerify,
+}
+
+func (flags *HttpProxyFlag) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	var v string
+	unmarshal(&v)
+	*flags = 0
+	parseOptions(v, func(flag string) bool {
+		if fl, ok := httpProxyFlagMap[flag]; ok {
+			*flags |= fl
+			return true
+		}
+		err = fmt.Errorf("unknown flag '%v'", flag)
+		return false
+	})
+	return
+}
+
+func (flags HttpProxyFlag) String() string {
+	var res strings.Builder
+	for s, mask := range httpProxyFlagMap {
+		if (flags & mask) != 0 {
+			if res.Len() > 0 {
+				res.WriteString(",")
+			}
+			res.WriteString(s)
+		}
+	}
+	return res.String()
+}
+
+func (flags HttpProxyFlag) MarshalYAML() (interface{}, error) {
+	return flags.String(), nil
+}
+
 type LoginConfig struct {
 	Title          string `yaml:"title,omitempty"`
 	Header         string `yaml:"header,omitempty"`
@@ -262,6 +537,7 @@ type User struct {
 	Name     string `yaml:"name"`
 	Password string `yaml:"password"`
 	Scope    string `yaml:"scope,omitempty"`
+	Groups   string `yaml:"groups,omitempty"`
 }
 
 // Client struct
@@ -277,6 +553,16 @@ type Client struct {
 // YandexHome struct
 type YandexHome struct {
 	Devices []YandexHomeDeviceConfig `yaml:"devices,omitempty"`
+	Access  []YandexHomeAccessConfig `yaml:"access,omitempty"`
+}
+
+// YandexHomeAccessConfig struct
+type YandexHomeAccessConfig struct {
+	Users   string   `yaml:"users,omitempty"`
+	Groups  string   `yaml:"groups,omitempty"`
+	Devices []string `yaml:"devices,omitempty"`
+	Rooms   []string `yaml:"rooms,omitempty"`
+	Tags    []string `yaml:"tags,omitempty"`
 }
 
 // YandexHomeDeviceConfig struct
@@ -286,6 +572,7 @@ type YandexHomeDeviceConfig struct {
 	Description  string                       `yaml:"description,omitempty"`
 	Room         string                       `yaml:"room,omitempty"`
 	Type         string                       `yaml:"type"`
+	Tags         []string                     `yaml:"tags,omitempty"`
 	ZwID         byte                         `yaml:"zwid"`
 	Capabilities []YandexHomeCapabilityConfig `yaml:"capabilities,omitempty"`
 }
@@ -341,7 +628,30 @@ type YandexHomeParametersRangeConfig struct {
 
 // YandexDialogs struct
 type YandexDialogs struct {
-	Tales string `yaml:"tales,omitempty"`
+	Tales          string                `yaml:"tales,omitempty"`
+	Sounds         *YandexDialogsSounds  `yaml:"sounds,omitempty"`         // used by tales sync action only
+	MatchThreshold float64               `yaml:"matchThreshold,omitempty"` // minimal similarity of the tale key and the spoken word, default is 0.8
+	StateFile      string                `yaml:"stateFile,omitempty"`      // file to keep tales history and favorites of the users
+	PartsPerTurn   int                   `yaml:"partsPerTurn,omitempty"`   // number of tale parts played per response, default is 3
+	Skills         []*YandexDialogsSkill `yaml:"skills,omitempty"`         // the skills and their routes, the tales skill on the dedicated route by default
+	SkillIDs       []string              `yaml:"skillIds,omitempty"`       // allowed skill IDs for the skills without own list, any skill ID is allowed by default
+	Secret         string                `yaml:"secret,omitempty"`         // secret path segment appended to the routes of the skills without own secret
+}
+
+// YandexDialogsSkill struct, type is tales, home-status or home-control
+type YandexDialogsSkill struct {
+	Route          `yaml:",inline"`
+	Scope          string   `yaml:"scope,omitempty"`          // scope required from the linked account, default is yandex-home for home-status and home-control, yandex-dialogs for tales
+	AccountLinking *bool    `yaml:"accountLinking,omitempty"` // the skill requires the linked account, default is true
+	SkillIDs       []string `yaml:"skillIds,omitempty"`       // allowed skill IDs
+	Secret         string   `yaml:"secret,omitempty"`         // secret path segment appended to the route, i.e. /yandex/dialogs/tales/<secret>
+}
+
+// YandexDialogsSounds struct
+type YandexDialogsSounds struct {
+	URL     string `yaml:"url,omitempty"` // Dialogs API URL, default is https://dialogs.yandex.net/api/v1
+	SkillID string `yaml:"skillId,omitempty"`
+	Token   string `yaml:"token,omitempty"` // OAuth token
 }
 
 // ZwCmd struct
@@ -391,13 +701,18 @@ func loadConfig(cfgFile string) error {
 	validate := []func(cfgError configError){
 		validateHTTPServerConfig,
 		validateRouteConfig,
+		validateYandexDialogsConfig,
 		validateAssetConfig,
+		validateProxyConfig,
+		validateRedirectConfig,
 		login.validateConfig,
 		validateCredentialsConfig,
 		validateAuthorizationConfig,
 		validateYandexHomeConfig,
 		validateZwCmdConfig,
 		validateYandexDialogsTalesConfig,
+		validateYandexDialogsTalesMatchConfig,
+		validateYandexDialogsTalesUsersConfig,
 		validateAlexaHomeConnectConfig,
 	}
 	for _, v := range validate {
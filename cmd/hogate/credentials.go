@@ -20,6 +20,7 @@ type userInfo struct {
 	name     string
 	password string
 	scope    scopeSet
+	groups   scopeSet
 }
 
 const (
@@ -66,7 +67,7 @@ func validateCredentialsConfig(cfgError configError) {
 			userError("scope cannot be empty")
 		}
 
-		credentials.users[user.Name] = userInfo{name: user.Name, password: user.Password, scope: scope}
+		credentials.users[user.Name] = userInfo{name: user.Name, password: user.Password, scope: scope, groups: parseScope(user.Groups)}
 	}
 
 	for i, client := range config.Credentials.Clients {
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Forward authentication endpoint for the reverse proxies like nginx (auth_request) or Traefik (forwardAuth).
+// Required scopes are passed by "scope" query parameter or X-Auth-Request-Scope header.
+// If "redirect" query parameter is set, unauthorized requests are redirected to the login page,
+// otherwise 401 is returned along with the login page URL in X-Auth-Redirect header.
+
+func addForwardAuthRoutes(router *http.ServeMux) {
+	handleDedicatedRoute(router, routeAuthVerify, http.HandlerFunc(forwardAuthVerify))
+}
+
+func forwardAuthVerify(w http.ResponseWriter, r *http.Request) {
+	scope := r.URL.Query().Get("scope")
+	if scope == "" {
+		scope = r.Header.Get("X-Auth-Request-Scope")
+	}
+	parsedScope := parseScope(scope)
+	requiredScope := make([]string, 0, len(parsedScope))
+	for k := range parsedScope {
+		requiredScope = append(requiredScope, k)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	status, claim := testAuthorization(r, requiredScope...)
+	if status == http.StatusOK {
+		user := claim.UserName
+		if user == "" {
+			user = claim.ClientID
+		}
+		grantedScope := newScopeSet(claim.Scope...)
+		if len(grantedScope) == 0 && claim.UserName != "" {
+			if ui, ok := credentials.user(claim.UserName); ok {
+				grantedScope = ui.scope
+			}
+		}
+		w.Header().Set("X-Auth-User", user)
+		w.Header().Set("X-Auth-Scope", grantedScope.String())
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	loginURL := fmt.Sprintf(
+		"%s?redirect_uri=%s",
+		dedicatedRoutes[routeLogin].path, url.QueryEscape(forwardAuthOriginalURL(r)),
+	)
+	if len(requiredScope) > 0 {
+		loginURL = fmt.Sprintf("%s&scope=%s", loginURL, url.QueryEscape(strings.Join(requiredScope, ",")))
+	}
+
+	if _, ok := r.URL.Query()["redirect"]; ok {
+		w.Header().Set("Location", loginURL)
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+	w.Header().Set("X-Auth-Redirect", loginURL)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+// forwardAuthOriginalURL restores URL of the request which the reverse proxy is authorizing
+func forwardAuthOriginalURL(r *http.Request) string {
+	if rd := r.URL.Query().Get("rd"); rd != "" {
+		return rd
+	}
+	if originalURL := r.Header.Get("X-Original-URL"); originalURL != "" {
+		return originalURL
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		proto := r.Header.Get("X-Forwarded-Proto")
+		if proto == "" {
+			proto = "https"
+		}
+		return proto + "://" + host + r.Header.Get("X-Forwarded-Uri")
+	}
+	return "/"
+}
@@ -27,6 +27,8 @@ type httpServer struct {
 	lock   sync.Mutex
 }
 
+var trustedProxies []*net.IPNet
+
 type logData map[string]map[string]string
 
 type httpLogMessageKey struct{}
@@ -36,6 +38,15 @@ func validateHTTPServerConfig(cfgError configError) {
 		cfgError("httpServer.port must be between 1 and 65535.")
 	}
 
+	trustedProxies = nil
+	for _, v := range config.HTTPServer.TrustedProxies {
+		if ipNet, err := parseCIDR(v); err != nil {
+			cfgError(fmt.Sprintf("httpServer.trustedProxies value '%v' is not valid: %v", v, err))
+		} else {
+			trustedProxies = append(trustedProxies, ipNet)
+		}
+	}
+
 	if config.HTTPServer.Log != nil {
 		if config.HTTPServer.Log.Dir == "" {
 			cfgError("httpServer.log.dir is required.")
@@ -141,12 +152,17 @@ func (srv *httpServer) init(errorLog *log.Logger) (useTLS bool, tlsCertFile, tls
 	router := http.NewServeMux()
 	addOAuthRoutes(router)
 	login.addRounte(router)
+	addForwardAuthRoutes(router)
 	addYandexHomeRoutes(router)
 	addYandexDialogsRoutes(router)
 	addAmazonAlexaRoutes(router)
+	addProxyRoutes(router)
 	addAssetRoutes(router)
 
 	var handler http.Handler = router
+	if len(redirectRules) > 0 {
+		handler = redirectHandler(handler)
+	}
 	if config.HTTPServer.Log != nil {
 		handler = logHandler(errorLog)(handler)
 	}
@@ -168,6 +184,7 @@ func (srv *httpServer) init(errorLog *log.Logger) (useTLS bool, tlsCertFile, tls
 func (srv *httpServer) start(errorLog *log.Logger) error {
 
 	useTLS, tlsCertFile, tlsKeyFile := srv.init(errorLog)
+	defer stopProxyHealthChecks()
 
 	// create TCP listener
 	netListener, err := net.Listen("tcp", ":"+strconv.Itoa(int(config.HTTPServer.Port)))
@@ -208,6 +225,49 @@ func (srv *httpServer) stop() error {
 	return nil
 }
 
+func trustedProxy(ip net.IP) bool {
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpClientIP returns address of the client. Forwarding headers are taken into account only
+// if the request came from one of the trusted proxies.
+func httpClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !trustedProxy(ip) {
+		return host
+	}
+
+	// X-Forwarded-For is walked from the right, skipping trusted proxies
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		forwarded := strings.Split(strings.Join(values, ","), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(forwarded[i])
+			if addr == "" {
+				continue
+			}
+			host = addr
+			if fip := net.ParseIP(addr); fip == nil || !trustedProxy(fip) {
+				break
+			}
+		}
+		return host
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return host
+}
+
 type logResponseWriter struct {
 	http.ResponseWriter
 	statusCode    int
@@ -225,6 +285,11 @@ func (w *logResponseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Unwrap allows http.ResponseController to reach Flush and Hijack of the underlying writer
+func (w *logResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func httpSetLogBulkData(r *http.Request, data logData) {
 	if d, ok := r.Context().Value(httpLogMessageKey{}).(logData); ok {
 		for gn, gv := range data {
@@ -32,12 +32,18 @@ stop
   Stop the service
 run [option]
   Execut as console application
+media <command> [options]
+  Convert and split media files for Yandex Dialogs tales, run "media" for details
+tales sync [options]
+  Synchronize Yandex Dialogs sounds with the media manifest and the tales file, run "tales" for details
+simulate [options]
+  Talk to Yandex Dialogs or Alexa skill in the console or replay the conversation script, run "simulate --help" for details
 
 Options:
 -h, --help
   Print this message
 -c, --config <file name>
-  Path to configuration yaml file. Works only for install and run actions.
+  Path to configuration yaml file. Works only for install, run, tales and simulate actions.
   Default: %v
 `,
 		defaultConfigFile(),
@@ -83,10 +89,23 @@ func (app *application) parseCommandLine(interactive bool) (action string) {
 				action = arg
 			}
 		}
+		if action == "media" || action == "tales" || action == "simulate" {
+			break // the rest of arguments belongs to the action
+		}
 	}
 	return
 }
 
+// actionArgs returns command line arguments which follow the action
+func actionArgs(action string) []string {
+	for i, arg := range os.Args[1:] {
+		if arg == action {
+			return os.Args[i+2:]
+		}
+	}
+	return nil
+}
+
 func (app *application) run() {
 	app.logger.Info(appName + " started with configuration file " + app.configFile)
 
@@ -130,6 +149,21 @@ func main() {
 	app := &application{}
 	action := app.parseCommandLine(service.Interactive())
 
+	switch action {
+	case "media":
+		os.Exit(mediaAction(actionArgs(action)))
+	case "tales":
+		if app.configFile == "" {
+			app.configFile = defaultConfigFile()
+		}
+		os.Exit(talesAction(app.configFile, actionArgs(action)))
+	case "simulate":
+		if app.configFile == "" {
+			app.configFile = defaultConfigFile()
+		}
+		os.Exit(simulateAction(app.configFile, actionArgs(action)))
+	}
+
 	var arguments []string
 	if app.configFile != "" {
 		arguments = []string{"--config", app.configFile}
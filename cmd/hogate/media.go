@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const mediaDefaultManifest = "media.json"
+const mediaDefaultSegment = 120 * time.Second
+
+type mediaFormat struct {
+	extension string
+	args      []string
+}
+
+var mediaFormats = map[string]mediaFormat{
+	"opus": {extension: ".opus", args: []string{"-ac", "1", "-c:a", "libopus"}},
+	"mp3":  {extension: ".mp3", args: []string{"-q:a", "0"}},
+}
+
+// mediaManifest tracks the files produced from the sources, so the sources are processed only once
+type mediaManifest struct {
+	Tales []*mediaManifestTale `json:"tales"`
+}
+
+type mediaManifestTale struct {
+	Name       string               `json:"name"`
+	Type       string               `json:"type"`
+	Length     int32                `json:"length"` // seconds
+	Source     string               `json:"source"`
+	SourceSize int64                `json:"sourceSize"`
+	SourceTime time.Time            `json:"sourceTime"`
+	Parts      []*mediaManifestPart `json:"parts"`
+}
+
+type mediaManifestPart struct {
+	File string `json:"file"`
+	ID   string `json:"id,omitempty"`   // sound identifier, once uploaded
+	Hash string `json:"hash,omitempty"` // SHA-256 of the uploaded content
+}
+
+type mediaOptions struct {
+	ffmpeg   string
+	output   string
+	manifest string
+	format   string
+	taleType string
+	name     string
+	segment  time.Duration
+	cutMap   string
+	force    bool
+}
+
+var mediaDurationRegex = regexp.MustCompile(`Duration:\s*(\d+):(\d+):(\d+(?:\.\d+)?)`)
+
+func mediaUsage() {
+	fmt.Printf(
+		`Usage: media <command> [options] [files]
+
+Commands:
+
+split [options] <files>
+  Convert and split the files into parts of the fixed length or by the cut map
+convert [options] <files>
+  Convert the files without splitting
+tales [options]
+  Print yandexDialogs.tales entries for the files in the manifest
+
+Options:
+--ffmpeg <path>
+  Path to ffmpeg binary. Default: HOGATE_FFMPEG environment variable or ffmpeg
+--output <dir>
+  Output directory. Default: current directory
+--manifest <file>
+  Path to the manifest file. Default: <output>/%v
+--format <opus|mp3>
+  Output format. Default: opus
+--type <type>
+  Tale type: fairytale, story, song, verse, joke or the category declared in the tales file. Default: story
+--name <name>
+  Tale name, only for the single file. Default: the file name without extension
+--segment <duration>
+  Length of the part. Default: %v
+--map <file>
+  Cut map, each line contains ffmpeg options selecting the part, like "-ss 0 -to 95"
+--force
+  Process the files even if they did not change
+`,
+		mediaDefaultManifest, mediaDefaultSegment,
+	)
+}
+
+func mediaAction(args []string) int {
+	if len(args) < 1 {
+		mediaUsage()
+		return 2
+	}
+
+	command := args[0]
+	opts := mediaOptions{}
+	fs := flag.NewFlagSet("media "+command, flag.ContinueOnError)
+	fs.Usage = mediaUsage
+	ffmpeg := os.Getenv("HOGATE_FFMPEG")
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	fs.StringVar(&opts.ffmpeg, "ffmpeg", ffmpeg, "")
+	fs.StringVar(&opts.output, "output", ".", "")
+	fs.StringVar(&opts.manifest, "manifest", "", "")
+	fs.StringVar(&opts.format, "format", "opus", "")
+	fs.StringVar(&opts.taleType, "type", "story", "")
+	fs.StringVar(&opts.name, "name", "", "")
+	fs.StringVar(&opts.cutMap, "map", "", "")
+	fs.BoolVar(&opts.force, "force", false, "")
+	segment := fs.String("segment", "", "")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if opts.manifest == "" {
+		opts.manifest = filepath.Join(opts.output, mediaDefaultManifest)
+	}
+
+	opts.segment = mediaDefaultSegment
+	if *segment != "" {
+		v, err := parseTimeDuration(*segment)
+		if err == nil && v < time.Second {
+			err = fmt.Errorf("at least one second expected")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid segment '%v': %v\n", *segment, err)
+			return 2
+		}
+		opts.segment = v
+	}
+	if _, ok := mediaFormats[opts.format]; !ok {
+		fmt.Fprintf(os.Stderr, "Unknown format '%v'.\n", opts.format)
+		return 2
+	}
+	if strings.TrimSpace(opts.taleType) == "" {
+		fmt.Fprintln(os.Stderr, "The tale type could not be empty.")
+		return 2
+	}
+	if opts.name != "" && fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "The name could be specified only for the single file.")
+		return 2
+	}
+
+	manifest, err := loadMediaManifest(opts.manifest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load the manifest: %v\n", err)
+		return 1
+	}
+
+	switch command {
+	case "split", "convert":
+		if fs.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "At least one file expected.")
+			return 2
+		}
+		if err := os.MkdirAll(opts.output, 0755); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to create output directory: %v\n", err)
+			return 1
+		}
+		rc := 0
+		for _, file := range fs.Args() {
+			if err := manifest.process(&opts, file, command == "split"); err != nil {
+				fmt.Fprintf(os.Stderr, "%v: %v\n", file, err)
+				rc = 1
+			}
+			// save after every file, so the completed work is not lost
+			if err := manifest.save(opts.manifest); err != nil {
+				fmt.Fprintf(os.Stderr, "Unable to save the manifest: %v\n", err)
+				return 1
+			}
+		}
+		return rc
+	case "tales":
+		if err := manifest.writeTales(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	mediaUsage()
+	return 2
+}
+
+func loadMediaManifest(path string) (*mediaManifest, error) {
+	m := &mediaManifest{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *mediaManifest) save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (m *mediaManifest) tale(name string) (int, *mediaManifestTale) {
+	for i, t := range m.Tales {
+		if t.Name == name {
+			return i, t
+		}
+	}
+	return -1, nil
+}
+
+func (m *mediaManifest) process(opts *mediaOptions, file string, split bool) error {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	source, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	name := opts.name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+
+	index, prev := m.tale(name)
+	if prev != nil && !opts.force && prev.Source == source && prev.SourceSize == fi.Size() && prev.SourceTime.Equal(fi.ModTime().UTC()) {
+		fmt.Printf("%v: not changed, skipped\n", file)
+		return nil
+	}
+	if prev != nil {
+		for _, part := range prev.Parts {
+			os.Remove(filepath.Join(opts.output, part.File))
+		}
+	}
+
+	format := mediaFormats[opts.format]
+	var duration time.Duration
+	var files []string
+	switch {
+	case !split:
+		files = []string{name + format.extension}
+		duration, err = runFFmpeg(opts.ffmpeg, "-i", source, "-map", "0:a", format.args, filepath.Join(opts.output, files[0]))
+	case opts.cutMap != "":
+		duration, files, err = splitByMap(opts, source, name, format)
+	default:
+		pattern := filepath.Join(opts.output, name+"_%03d"+format.extension)
+		duration, err = runFFmpeg(opts.ffmpeg, "-i", source, "-map", "0:a", "-f", "segment",
+			"-segment_time", strconv.FormatInt(int64(opts.segment/time.Second), 10), format.args, pattern)
+		if err == nil {
+			files, err = segmentFiles(opts.output, name, format.extension)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no output produced")
+	}
+
+	length := int32(math.Ceil(duration.Seconds()))
+	if length <= 0 {
+		length = int32(len(files)) * int32(opts.segment/time.Second)
+	}
+
+	tale := &mediaManifestTale{
+		Name:       name,
+		Type:       opts.taleType,
+		Length:     length,
+		Source:     source,
+		SourceSize: fi.Size(),
+		SourceTime: fi.ModTime().UTC(),
+	}
+	for _, f := range files {
+		tale.Parts = append(tale.Parts, &mediaManifestPart{File: f})
+	}
+	if index >= 0 {
+		m.Tales[index] = tale
+	} else {
+		m.Tales = append(m.Tales, tale)
+	}
+
+	fmt.Printf("%v: %v part(s), %v second(s)\n", file, len(files), length)
+	return nil
+}
+
+func splitByMap(opts *mediaOptions, source, name string, format mediaFormat) (time.Duration, []string, error) {
+	f, err := os.Open(opts.cutMap)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	var duration time.Duration
+	var files []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		file := fmt.Sprintf("%s_%03d%s", name, len(files), format.extension)
+		d, err := runFFmpeg(opts.ffmpeg, "-i", source, strings.Fields(line), format.args, filepath.Join(opts.output, file))
+		if err != nil {
+			return 0, nil, err
+		}
+		duration = d
+		files = append(files, file)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	return duration, files, nil
+}
+
+// segmentFiles returns the parts written by ffmpeg segment muxer in the order of the part number
+func segmentFiles(dir, name, extension string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(name) + `_(\d{3,})` + regexp.QuoteMeta(extension) + "$")
+	type part struct {
+		file    string
+		ordinal int
+	}
+	var parts []part
+	for _, e := range entries {
+		if m := re.FindStringSubmatch(e.Name()); m != nil && !e.IsDir() {
+			ordinal, _ := strconv.Atoi(m[1])
+			parts = append(parts, part{file: e.Name(), ordinal: ordinal})
+		}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].ordinal < parts[j].ordinal })
+	files := make([]string, len(parts))
+	for i, p := range parts {
+		files[i] = p.file
+	}
+	return files, nil
+}
+
+// runFFmpeg runs ffmpeg with the arguments (strings or string slices) and returns the duration of the input
+func runFFmpeg(ffmpeg string, args ...interface{}) (time.Duration, error) {
+	cmdArgs := []string{"-hide_banner", "-nostdin", "-y"}
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case string:
+			cmdArgs = append(cmdArgs, v)
+		case []string:
+			cmdArgs = append(cmdArgs, v...)
+		}
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(ffmpeg, cmdArgs...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if len(lines) > 5 {
+			lines = lines[len(lines)-5:]
+		}
+		return 0, fmt.Errorf("ffmpeg failed: %v%v%v", err, NewLine, strings.Join(lines, NewLine))
+	}
+
+	var duration time.Duration
+	if m := mediaDurationRegex.FindStringSubmatch(stderr.String()); m != nil {
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		sec, _ := strconv.ParseFloat(m[3], 64)
+		duration = time.Duration(h)*time.Hour + time.Duration(min)*time.Minute + time.Duration(sec*float64(time.Second))
+	}
+	return duration, nil
+}
+
+// writeTales writes yandexDialogs.tales entries, parts not uploaded yet are referenced by the file names
+func (m *mediaManifest) writeTales(w io.Writer) error {
+	tales := make([]yandexDialogsTale, 0, len(m.Tales))
+	for _, t := range m.Tales {
+		tale := yandexDialogsTale{Name: t.Name, Type: t.Type, Length: t.Length}
+		for _, p := range t.Parts {
+			if p.ID != "" {
+				tale.Parts = append(tale.Parts, p.ID)
+			} else {
+				tale.Parts = append(tale.Parts, p.File)
+			}
+		}
+		tales = append(tales, tale)
+	}
+	data, err := yaml.Marshal(tales)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// mediaStubFFmpeg writes the script which reports the duration like ffmpeg does, creates the output files and logs the arguments
+func mediaStubFFmpeg(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub ffmpeg is the shell script")
+	}
+	stub := filepath.Join(dir, "ffmpeg")
+	log := filepath.Join(dir, "ffmpeg.log")
+	script := `#!/bin/sh
+echo "$@" >> "` + log + `"
+for last; do :; done
+echo "  Duration: 00:05:01.50, start: 0.000000, bitrate: 128 kb/s" >&2
+case "$last" in
+*%03d*) for i in 0 1 2; do : > "$(printf "$last" $i)"; done ;;
+*) : > "$last" ;;
+esac
+`
+	if err := os.WriteFile(stub, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return stub, log
+}
+
+func TestMediaSplit(t *testing.T) {
+	dir := t.TempDir()
+	stub, log := mediaStubFFmpeg(t, dir)
+	output := filepath.Join(dir, "out")
+	source := filepath.Join(dir, "kolobok.mp3")
+	if err := os.WriteFile(source, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"split", "--ffmpeg", stub, "--output", output, "--type", "fairytale", "--name", "Колобок", source}
+	if rc := mediaAction(args); rc != 0 {
+		t.Fatalf("media split exit code %v", rc)
+	}
+
+	calls, _ := os.ReadFile(log)
+	if !strings.Contains(string(calls), "-f segment -segment_time 120 -ac 1 -c:a libopus") {
+		t.Errorf("unexpected ffmpeg arguments: %s", calls)
+	}
+
+	manifest, err := loadMediaManifest(filepath.Join(output, mediaDefaultManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Tales) != 1 {
+		t.Fatalf("got %v tales in the manifest, want 1", len(manifest.Tales))
+	}
+	tale := manifest.Tales[0]
+	if tale.Name != "Колобок" || tale.Type != "fairytale" || tale.Length != 302 || tale.Source != source || tale.SourceSize != 5 {
+		t.Errorf("unexpected manifest entry %+v", tale)
+	}
+	var files []string
+	for _, p := range tale.Parts {
+		files = append(files, p.File)
+	}
+	if strings.Join(files, " ") != "Колобок_000.opus Колобок_001.opus Колобок_002.opus" {
+		t.Errorf("unexpected parts %v", files)
+	}
+
+	// the unchanged source is skipped
+	if rc := mediaAction(args); rc != 0 {
+		t.Fatalf("media split exit code %v", rc)
+	}
+	if again, _ := os.ReadFile(log); !bytes.Equal(again, calls) {
+		t.Error("the unchanged source is processed again")
+	}
+
+	manifest.Tales[0].Parts[1].ID = "uploaded-id"
+	var out bytes.Buffer
+	if err := manifest.writeTales(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `- name: Колобок
+  type: fairytale
+  length: 302
+  parts:
+  - Колобок_000.opus
+  - uploaded-id
+  - Колобок_002.opus
+`
+	if out.String() != expected {
+		t.Errorf("unexpected tales:\n%v\nwant:\n%v", out.String(), expected)
+	}
+}
+
+func TestMediaConvert(t *testing.T) {
+	dir := t.TempDir()
+	stub, _ := mediaStubFFmpeg(t, dir)
+	source := filepath.Join(dir, "song.wav")
+	if err := os.WriteFile(source, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if rc := mediaAction([]string{"convert", "--ffmpeg", stub, "--output", dir, "--format", "mp3", "--type", "song", source}); rc != 0 {
+		t.Fatalf("media convert exit code %v", rc)
+	}
+	manifest, err := loadMediaManifest(filepath.Join(dir, mediaDefaultManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Tales) != 1 || len(manifest.Tales[0].Parts) != 1 || manifest.Tales[0].Parts[0].File != "song.mp3" || manifest.Tales[0].Type != "song" {
+		t.Fatalf("unexpected manifest %+v", manifest.Tales)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "song.mp3")); err != nil {
+		t.Error(err)
+	}
+}
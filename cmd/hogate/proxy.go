@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type proxy HTTPProxy
+
+const proxyDefaultDialTimeout = 30 * time.Second
+const proxyDefaultHealthInterval = 30 * time.Second
+const proxyDefaultHealthTimeout = 5 * time.Second
+
+type proxyRuntime struct {
+	target          *url.URL
+	dialTimeout     time.Duration
+	responseTimeout time.Duration
+	flushInterval   time.Duration
+	healthInterval  time.Duration
+	healthTimeout   time.Duration
+	unhealthy       atomic.Bool
+}
+
+var proxyRuntimes map[*HTTPProxy]*proxyRuntime
+
+var proxyHealthStop chan struct{}
+var proxyHealthStopLock sync.Mutex
+
+func validateProxyConfig(cfgError configError) {
+	proxyRuntimes = make(map[*HTTPProxy]*proxyRuntime)
+	routes := dedicatedRoutePaths()
+	for _, ast := range config.Assets {
+		routes[ast.Route] = struct{}{}
+	}
+	for i, px := range config.Proxies {
+		proxyError := func(msg string) {
+			cfgError(fmt.Sprintf("proxies, proxy %v: %v", i, msg))
+		}
+		if px == nil {
+			continue
+		}
+
+		p := (*proxy)(px)
+		if rt, err := p.valid(routes); err != nil {
+			proxyError(err.Error())
+		} else {
+			proxyRuntimes[px] = rt
+			routes[p.Route] = struct{}{}
+		}
+
+		validateRoutePropertiesConfig(px, &px.routeBase, proxyError)
+	}
+}
+
+func (p *proxy) valid(routes map[string]struct{}) (*proxyRuntime, error) {
+	if _, ok := routes[p.Route]; ok || p.Route == "" {
+		return nil, fmt.Errorf("the route '%s' already exists", p.Route)
+	}
+
+	rt := &proxyRuntime{dialTimeout: proxyDefaultDialTimeout}
+
+	target, err := url.Parse(p.Upstream)
+	if err == nil && !(target.Scheme == "http" || target.Scheme == "https") {
+		err = fmt.Errorf("only http and https schemes are supported")
+	}
+	if err == nil && target.Host == "" {
+		err = fmt.Errorf("host is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("the route '%s' has invalid upstream '%s': %v", p.Route, p.Upstream, err)
+	}
+	rt.target = target
+
+	parseDuration := func(value, name string, dest *time.Duration) error {
+		if value == "" {
+			return nil
+		}
+		duration, err := parseTimeDuration(value)
+		if err == nil && duration < 0 {
+			err = fmt.Errorf("negative value not allowed")
+		}
+		if err != nil {
+			return fmt.Errorf("the route '%s' has invalid %s '%s': %v", p.Route, name, value, err)
+		}
+		*dest = duration
+		return nil
+	}
+
+	if err := parseDuration(p.DialTimeout, "dialTimeout", &rt.dialTimeout); err != nil {
+		return nil, err
+	}
+	if err := parseDuration(p.ResponseTimeout, "responseTimeout", &rt.responseTimeout); err != nil {
+		return nil, err
+	}
+	if err := parseDuration(p.FlushInterval, "flushInterval", &rt.flushInterval); err != nil {
+		return nil, err
+	}
+
+	if p.HealthCheck != nil {
+		rt.healthInterval = proxyDefaultHealthInterval
+		rt.healthTimeout = proxyDefaultHealthTimeout
+		if err := parseDuration(p.HealthCheck.Interval, "healthCheck.interval", &rt.healthInterval); err != nil {
+			return nil, err
+		}
+		if err := parseDuration(p.HealthCheck.Timeout, "healthCheck.timeout", &rt.healthTimeout); err != nil {
+			return nil, err
+		}
+		if rt.healthInterval <= 0 {
+			return nil, fmt.Errorf("the route '%s' has zero healthCheck.interval", p.Route)
+		}
+	}
+
+	return rt, nil
+}
+
+func addProxyRoutes(router *http.ServeMux) {
+	proxyHealthStopLock.Lock()
+	proxyHealthStop = make(chan struct{})
+	proxyHealthStopLock.Unlock()
+
+	for _, px := range config.Proxies {
+		rt, ok := proxyRuntimes[px]
+		if !ok {
+			continue
+		}
+		p := (*proxy)(px)
+
+		scope := parseScope(p.Scope)
+		p.parsedScope = make([]string, 0, len(scope))
+		for k := range scope {
+			p.parsedScope = append(p.parsedScope, k)
+		}
+
+		transport := p.transport(rt)
+		handler := p.handler(rt, transport)
+		router.Handle(px.Route, px.applyHandlers(handler))
+
+		if p.HealthCheck != nil {
+			backgroundBlock.Add(1)
+			go p.healthCheck(rt, &http.Client{Transport: transport, Timeout: rt.healthTimeout}, proxyHealthStop)
+		}
+	}
+}
+
+func stopProxyHealthChecks() {
+	proxyHealthStopLock.Lock()
+	defer proxyHealthStopLock.Unlock()
+	if proxyHealthStop != nil {
+		close(proxyHealthStop)
+		proxyHealthStop = nil
+	}
+}
+
+func (p *proxy) transport(rt *proxyRuntime) *http.Transport {
+	dialer := &net.Dialer{Timeout: rt.dialTimeout, KeepAlive: 30 * time.Second}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = rt.responseTimeout
+	if p.Socket != "" {
+		socket := p.Socket
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+	} else {
+		transport.DialContext = dialer.DialContext
+	}
+	if (p.Flags & HPFInsecureSkipVerify) != 0 {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return transport
+}
+
+func (p *proxy) handler(rt *proxyRuntime, transport http.RoundTripper) http.Handler {
+	reverseProxy := &httputil.ReverseProxy{
+		Transport:     transport,
+		FlushInterval: rt.flushInterval,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			if (p.Flags & HPFStripPrefix) != 0 {
+				prefix := strings.TrimSuffix(p.Route, "/")
+				pr.Out.URL.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(pr.Out.URL.Path, prefix), "/")
+				pr.Out.URL.RawPath = ""
+			}
+			pr.SetURL(rt.target)
+			pr.SetXForwarded()
+			if (p.Flags & HPFPreserveHost) != 0 {
+				pr.Out.Host = pr.In.Host
+			}
+			proxyRemoveAuthCredentials(pr.Out)
+			if p.RewriteHeaders != nil {
+				rewriteHeaders(pr.Out.Header, p.RewriteHeaders.Request)
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if p.RewriteHeaders != nil {
+				rewriteHeaders(resp.Header, p.RewriteHeaders.Response)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			httpSetLogBulkData(r, logData{
+				"proxy": {"error": err.Error()},
+			})
+			status := http.StatusBadGateway
+			if r.Context().Err() == nil && isTimeoutError(err) {
+				status = http.StatusGatewayTimeout
+			}
+			http.Error(w, http.StatusText(status), status)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (p.Flags&(HPFAuthenticate|HPFAuthorize)) != 0 && authorizeRequest(w, r, (p.Flags&HPFAuthorize) != 0, p.parsedScope) {
+			return
+		}
+		if rt.unhealthy.Load() {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		reverseProxy.ServeHTTP(w, r)
+	})
+}
+
+func (p *proxy) healthCheck(rt *proxyRuntime, client *http.Client, stop chan struct{}) {
+	defer backgroundBlock.Done()
+
+	target := rt.target.JoinPath(p.HealthCheck.Path).String()
+	check := func() {
+		healthy := false
+		if response, err := client.Get(target); err == nil {
+			response.Body.Close()
+			healthy = response.StatusCode < http.StatusInternalServerError
+		}
+		rt.unhealthy.Store(!healthy)
+	}
+
+	check()
+	ticker := time.NewTicker(rt.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+// proxyRemoveAuthCredentials removes hogate authorization cookie and bearer token from the request sent to upstream
+func proxyRemoveAuthCredentials(r *http.Request) {
+	if authorization := r.Header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		if _, err := parseAuthToken(authorization[7:]); err == nil {
+			r.Header.Del("Authorization")
+		}
+	}
+
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != authTokenCookie {
+			r.AddCookie(cookie)
+		}
+	}
+}
+
+func rewriteHeaders(header http.Header, rules map[string]string) {
+	for k, v := range rules {
+		if v == "" {
+			header.Del(k)
+		} else {
+			header.Set(k, v)
+		}
+	}
+}
+
+func isTimeoutError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return ne.Timeout()
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
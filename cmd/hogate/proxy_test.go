@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestProxyRemoveAuthCredentials(t *testing.T) {
+	authTokenSecret = []byte("secret")
+	token, err := createAuthToken(authTokenAccess, "client", "user", parseScope("scope"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "http://upstream/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.AddCookie(&http.Cookie{Name: authTokenCookie, Value: token})
+	r.AddCookie(&http.Cookie{Name: "other", Value: "value"})
+	proxyRemoveAuthCredentials(r)
+	if v := r.Header.Get("Authorization"); v != "" {
+		t.Errorf("hogate token is sent to upstream: %v", v)
+	}
+	if _, err := r.Cookie(authTokenCookie); err == nil {
+		t.Error("hogate cookie is sent to upstream")
+	}
+	if _, err := r.Cookie("other"); err != nil {
+		t.Error("other cookie is removed")
+	}
+
+	r.Header.Set("Authorization", "Bearer upstream-token")
+	proxyRemoveAuthCredentials(r)
+	if v := r.Header.Get("Authorization"); v != "Bearer upstream-token" {
+		t.Errorf("upstream token is removed: %v", v)
+	}
+}
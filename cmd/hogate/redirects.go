@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	redirectMatchExact = iota
+	redirectMatchPrefix
+	redirectMatchRegex
+)
+
+type redirectRule struct {
+	match         int
+	from          string
+	re            *regexp.Regexp
+	to            string
+	status        int
+	preserveQuery bool
+	rewrite       bool
+}
+
+var redirectRules []redirectRule
+
+func validateRedirectConfig(cfgError configError) {
+	redirectRules = nil
+	routes := dedicatedRoutePaths()
+
+	for i, rd := range config.Redirects {
+		redirectError := func(msg string) {
+			cfgError(fmt.Sprintf("redirects, redirect %v: %v", i, msg))
+		}
+
+		rule := redirectRule{
+			from:          rd.From,
+			to:            rd.To,
+			status:        rd.Status,
+			preserveQuery: rd.PreserveQuery,
+			rewrite:       rd.Rewrite,
+		}
+
+		switch strings.ToLower(rd.Match) {
+		case "", "exact":
+			rule.match = redirectMatchExact
+		case "prefix":
+			rule.match = redirectMatchPrefix
+		case "regex":
+			rule.match = redirectMatchRegex
+		default:
+			redirectError(fmt.Sprintf("unknown match '%v'.", rd.Match))
+			continue
+		}
+
+		if rule.from == "" {
+			redirectError("from cannot be empty.")
+			continue
+		}
+		if rule.to == "" {
+			redirectError("to cannot be empty.")
+			continue
+		}
+		if rule.match == redirectMatchRegex {
+			re, err := regexp.Compile(rule.from)
+			if err != nil {
+				redirectError(fmt.Sprintf("invalid from regex '%v': %v", rule.from, err))
+				continue
+			}
+			rule.re = re
+		} else if !strings.HasPrefix(rule.from, "/") {
+			redirectError(fmt.Sprintf("from '%v' must start with '/'.", rule.from))
+			continue
+		}
+
+		if rule.rewrite {
+			if u, err := url.Parse(rule.to); err != nil || u.IsAbs() || u.Host != "" {
+				redirectError(fmt.Sprintf("rewrite target '%v' must be a local path.", rule.to))
+				continue
+			}
+		} else {
+			switch rule.status {
+			case 0:
+				rule.status = http.StatusFound
+			case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			default:
+				redirectError(fmt.Sprintf("status %v is not supported.", rule.status))
+				continue
+			}
+		}
+
+		collision := false
+		for path := range routes {
+			if _, ok := rule.apply(path); ok {
+				redirectError(fmt.Sprintf("from '%v' collides with the dedicated route '%v'.", rule.from, path))
+				collision = true
+				break
+			}
+		}
+		if collision {
+			continue
+		}
+
+		redirectRules = append(redirectRules, rule)
+	}
+}
+
+// apply returns the target for the path if the rule matches it
+func (rule *redirectRule) apply(path string) (string, bool) {
+	switch rule.match {
+	case redirectMatchExact:
+		if path == rule.from {
+			return rule.to, true
+		}
+	case redirectMatchPrefix:
+		if strings.HasPrefix(path, rule.from) {
+			return rule.to + path[len(rule.from):], true
+		}
+	case redirectMatchRegex:
+		if m := rule.re.FindStringSubmatchIndex(path); m != nil {
+			return string(rule.re.ExpandString(nil, rule.to, path, m)), true
+		}
+	}
+	return "", false
+}
+
+func (rule *redirectRule) target(r *http.Request, to string) string {
+	if rule.preserveQuery && r.URL.RawQuery != "" {
+		if strings.Contains(to, "?") {
+			to += "&" + r.URL.RawQuery
+		} else {
+			to += "?" + r.URL.RawQuery
+		}
+	}
+	return to
+}
+
+// redirectHandler evaluates the redirect rules, in order, before the request is dispatched to the routes
+func redirectHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range redirectRules {
+			to, ok := rule.apply(r.URL.Path)
+			if !ok {
+				continue
+			}
+			to = rule.target(r, to)
+
+			if !rule.rewrite {
+				http.Redirect(w, r, to, rule.status)
+				return
+			}
+
+			u, err := url.Parse(to)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			httpSetLogBulkData(r, logData{
+				"redirect": {"rewrite": u.String()},
+			})
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = u.Path
+			r2.URL.RawPath = u.RawPath
+			r2.URL.RawQuery = u.RawQuery
+			r2.RequestURI = r2.URL.RequestURI()
+			next.ServeHTTP(w, r2)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"golang.org/x/time/rate"
@@ -18,6 +20,8 @@ const (
 
 	routeLogin
 
+	routeAuthVerify
+
 	routeYandexHomeHealth
 	routeYandexHomeUnlink
 	routeYandexHomeDevices
@@ -37,8 +41,17 @@ type routeBase struct {
 	originAny        bool
 	originIncludes   []*regexp.Regexp
 	originExcludes   []*regexp.Regexp
-	headers          string
+	headersAny       bool
+	headers          []string
+	exposeHeaders    string
+	corsMaxAge       int64 // seconds
 	allowCredentials bool
+	allowCidrs       []*net.IPNet
+	denyCidrs        []*net.IPNet
+	hsts             string
+	responseHeaders  map[string]string
+	compression      *compressionOptions
+	ownOptions       bool // the handler responds to OPTIONS requests itself
 }
 
 type routeInfo struct {
@@ -46,14 +59,22 @@ type routeInfo struct {
 	path string
 }
 
+// protects pages with the credential forms from clickjacking
+var credentialFormHeaders = map[string]string{
+	"Content-Security-Policy": "default-src 'none'; style-src 'unsafe-inline'; img-src data:; frame-ancestors 'none'",
+	"X-Frame-Options":         "DENY",
+	"Referrer-Policy":         "no-referrer",
+}
+
 var dedicatedRoutes = map[int]*routeInfo{
 	routeOAuthAuthorize: {
 		path: "/authorize",
 		routeBase: routeBase{
-			rateLimit:   10,
-			rateBurst:   3,
-			maxBodySize: 4096,
-			methods:     []string{"GET", "POST", "OPTIONS"},
+			rateLimit:       10,
+			rateBurst:       3,
+			maxBodySize:     4096,
+			methods:         []string{"GET", "POST", "OPTIONS"},
+			responseHeaders: credentialFormHeaders,
 		},
 	},
 	routeOAuthToken: {
@@ -68,10 +89,20 @@ var dedicatedRoutes = map[int]*routeInfo{
 	routeLogin: {
 		path: "/login",
 		routeBase: routeBase{
-			rateLimit:   5,
-			rateBurst:   2,
-			maxBodySize: 8196,
-			methods:     []string{"GET", "POST", "OPTIONS"},
+			rateLimit:       5,
+			rateBurst:       2,
+			maxBodySize:     8196,
+			methods:         []string{"GET", "POST", "OPTIONS"},
+			responseHeaders: credentialFormHeaders,
+		},
+	},
+
+	routeAuthVerify: {
+		path: "/auth/verify",
+		routeBase: routeBase{
+			rateLimit:   0,
+			rateBurst:   0,
+			maxBodySize: 256,
 		},
 	},
 
@@ -100,6 +131,7 @@ var dedicatedRoutes = map[int]*routeInfo{
 			rateBurst:   5,
 			maxBodySize: 256,
 			methods:     []string{"GET", "POST", "OPTIONS"},
+			compression: newCompressionOptions(),
 		},
 	},
 	routeYandexHomeQuery: {
@@ -195,8 +227,94 @@ func validateRoutePropertiesConfig(src RouteProperties, dest *routeBase, reportE
 		}
 	}
 
-	dest.headers = src.PropHeaders()
+	dest.headersAny = false
+	dest.headers = nil
+	parseOptions(src.PropHeaders(), func(header string) bool {
+		if header == "*" {
+			dest.headersAny = true
+		} else {
+			dest.headers = append(dest.headers, http.CanonicalHeaderKey(header))
+		}
+		return true
+	})
+	dest.exposeHeaders = src.PropExposeHeaders()
+
+	if src.PropCorsMaxAge() != "" {
+		maxAge, err := parseMaxAge(src.PropCorsMaxAge())
+		if err == nil && maxAge < 0 {
+			err = fmt.Errorf("negative value not allowed")
+		}
+		if err != nil {
+			reportError(fmt.Sprintf("invalid corsMaxAge value '%v': %v", src.PropCorsMaxAge(), err))
+		} else {
+			dest.corsMaxAge = int64(maxAge / time.Second)
+		}
+	}
+
 	dest.allowCredentials = src.PropAllowCredentials()
+
+	if rh := src.PropResponseHeaders(); rh != nil {
+		headers := make(map[string]string, len(dest.responseHeaders))
+		for k, v := range dest.responseHeaders {
+			headers[k] = v
+		}
+		set := func(name, value string) {
+			if value == "" {
+				delete(headers, name)
+			} else {
+				headers[name] = value
+			}
+		}
+		if rh.ContentSecurityPolicy != "" {
+			set("Content-Security-Policy", rh.ContentSecurityPolicy)
+		}
+		if rh.FrameOptions != "" {
+			set("X-Frame-Options", rh.FrameOptions)
+		}
+		if rh.ReferrerPolicy != "" {
+			set("Referrer-Policy", rh.ReferrerPolicy)
+		}
+		if rh.PermissionsPolicy != "" {
+			set("Permissions-Policy", rh.PermissionsPolicy)
+		}
+		for k, v := range rh.Extra {
+			set(http.CanonicalHeaderKey(k), v)
+		}
+		dest.responseHeaders = headers
+
+		if rh.HSTS != "" {
+			if hsts, err := parseHSTS(rh.HSTS); err != nil {
+				reportError(fmt.Sprintf("invalid responseHeaders.hsts value '%v': %v", rh.HSTS, err))
+			} else {
+				dest.hsts = hsts
+			}
+		}
+	}
+
+	if c := src.PropCompression(); c != nil {
+		if compression, err := parseCompression(c); err != nil {
+			reportError(fmt.Sprintf("invalid compression: %v", err))
+		} else {
+			dest.compression = compression
+		}
+	}
+
+	dest.allowCidrs = make([]*net.IPNet, 0, len(src.PropAllowCidrs()))
+	dest.denyCidrs = make([]*net.IPNet, 0, len(src.PropDenyCidrs()))
+	for _, val := range src.PropAllowCidrs() {
+		if ipNet, err := parseCIDR(val); err != nil {
+			reportError(fmt.Sprintf("invalid allowCidrs value '%v': %v", val, err))
+		} else {
+			dest.allowCidrs = append(dest.allowCidrs, ipNet)
+		}
+	}
+	for _, val := range src.PropDenyCidrs() {
+		if ipNet, err := parseCIDR(val); err != nil {
+			reportError(fmt.Sprintf("invalid denyCidrs value '%v': %v", val, err))
+		} else {
+			dest.denyCidrs = append(dest.denyCidrs, ipNet)
+		}
+	}
 }
 
 func validateRouteConfig(cfgError configError) {
@@ -239,6 +357,10 @@ func parseRouteType(t string) (int, error) {
 		return routeOAuthAuthorize, nil
 	case "oauth-token":
 		return routeOAuthToken, nil
+	case "login":
+		return routeLogin, nil
+	case "auth-verify":
+		return routeAuthVerify, nil
 	case "yandex-home-health":
 		return routeYandexHomeHealth, nil
 	case "yandex-home-unlink":
@@ -305,11 +427,61 @@ func parseRouteMethods(methods string) ([]string, error) {
 	return rv, nil
 }
 
+func parseHSTS(hsts string) (string, error) {
+	var sb strings.Builder
+	ok := parseOptions(hsts, func(option string) bool {
+		if sb.Len() == 0 {
+			maxAge, err := parseMaxAge(option)
+			if err != nil || maxAge < 0 {
+				return false
+			}
+			sb.WriteString("max-age=")
+			sb.WriteString(strconv.FormatInt(int64(maxAge/time.Second), 10))
+			return true
+		}
+		switch strings.ToLower(option) {
+		case "includesubdomains":
+			sb.WriteString("; includeSubDomains")
+		case "preload":
+			sb.WriteString("; preload")
+		default:
+			return false
+		}
+		return true
+	})
+	if !ok || sb.Len() == 0 {
+		return "", fmt.Errorf("expected max age optionally followed by includeSubDomains and/or preload")
+	}
+	return sb.String(), nil
+}
+
+// parseCIDR accepts either CIDR notation or a single IPv4/IPv6 address
+func parseCIDR(value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address")
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	return ipNet, err
+}
+
 func dedicatedRoutePaths() map[string]struct{} {
 	routes := make(map[string]struct{})
 	for _, ri := range dedicatedRoutes {
 		routes[ri.path] = struct{}{}
 	}
+	for _, skill := range ydSkills {
+		routes[skill.path] = struct{}{}
+	}
 	return routes
 }
 
@@ -344,60 +516,162 @@ func rateLimitHandler(rateLimit float64, rateBurst int) func(http.Handler) http.
 	}
 }
 
+func methodAllowed(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
 func limitMethodsHandler(methods []string) func(http.Handler) http.Handler {
 	methodsValue := strings.Join(methods, ", ")
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			notFound := true
-			for _, method := range methods {
-				if method == r.Method {
-					notFound = false
-					break
-				}
-			}
-			if notFound {
+			if !methodAllowed(methods, r.Method) {
+				w.Header().Set("Allow", methodsValue)
 				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
 				return
 			}
-			w.Header().Add("Access-Control-Allow-Methods", methodsValue)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-func originMethodHandler(originAny bool, originIncludes, originExcludes []*regexp.Regexp) func(http.Handler) http.Handler {
+func (rb *routeBase) corsEnabled() bool {
+	return rb.originAny || len(rb.originIncludes) > 0 || len(rb.originExcludes) > 0
+}
+
+func (rb *routeBase) originAllowed(origin string) bool {
+	return rb.originAny || !skipByPatterns(
+		rb.originIncludes, rb.originExcludes,
+		[]string{origin},
+		func(pattern *regexp.Regexp, value string) bool {
+			return pattern.MatchString(value)
+		},
+	)
+}
+
+func (rb *routeBase) headersAllowed(headers []string) bool {
+	if rb.headersAny {
+		return true
+	}
+	for _, header := range headers {
+		allowed := false
+		for _, h := range rb.headers {
+			if h == header {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// corsHandler evaluates both simple cross-origin requests and preflight requests.
+// Requests from the disallowed origins are rejected, same-origin requests are passed through as is.
+func corsHandler(rb *routeBase) func(http.Handler) http.Handler {
+	methodsValue := strings.Join(rb.methods, ", ")
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			origin := r.Header.Get("Origin")
-			if origin != "" {
-				if originAny || !skipByPatterns(
-					originIncludes, originExcludes,
-					[]string{origin},
-					func(pattern *regexp.Regexp, value string) bool {
-						return pattern.MatchString(value)
-					},
-				) {
-					w.Header().Add("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+			if origin == "" || sameOrigin(r, origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !rb.originAllowed(origin) {
+				http.Error(w, "Origin Not Allowed", http.StatusForbidden)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			if rb.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			requestMethod := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != "OPTIONS" || requestMethod == "" {
+				if rb.exposeHeaders != "" {
+					w.Header().Set("Access-Control-Expose-Headers", rb.exposeHeaders)
 				}
+				next.ServeHTTP(w, r)
+				return
 			}
-			next.ServeHTTP(w, r)
+
+			// preflight
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+			if len(rb.methods) > 0 && !methodAllowed(rb.methods, requestMethod) {
+				http.Error(w, "Method Not Allowed By CORS Policy", http.StatusForbidden)
+				return
+			}
+
+			var requestHeaders []string
+			parseOptions(r.Header.Get("Access-Control-Request-Headers"), func(header string) bool {
+				requestHeaders = append(requestHeaders, http.CanonicalHeaderKey(header))
+				return true
+			})
+			if !rb.headersAllowed(requestHeaders) {
+				http.Error(w, "Headers Not Allowed By CORS Policy", http.StatusForbidden)
+				return
+			}
+
+			if len(rb.methods) > 0 {
+				w.Header().Set("Access-Control-Allow-Methods", methodsValue)
+			} else {
+				w.Header().Set("Access-Control-Allow-Methods", requestMethod)
+			}
+			if len(requestHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(requestHeaders, ", "))
+			}
+			if rb.corsMaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.FormatInt(rb.corsMaxAge, 10))
+			}
+			w.WriteHeader(http.StatusNoContent)
 		})
 	}
 }
 
-func allowsHeadersHandler(headers string) func(http.Handler) http.Handler {
+func clientAddressHandler(allowCidrs, denyCidrs []*net.IPNet) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Add("Access-Control-Allow-Headers", headers)
+			if skipByPatterns(
+				allowCidrs, denyCidrs,
+				[]string{httpClientIP(r)},
+				func(pattern *net.IPNet, value string) bool {
+					ip := net.ParseIP(value)
+					return ip != nil && pattern.Contains(ip)
+				},
+			) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-func allowCredentialsHandler() func(http.Handler) http.Handler {
+func responseHeadersHandler(hsts string, headers map[string]string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Add("Access-Control-Allow-Credentials", "true")
+			for k, v := range headers {
+				w.Header().Set(k, v)
+			}
+			if hsts != "" && r.TLS != nil {
+				w.Header().Set("Strict-Transport-Security", hsts)
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -416,6 +690,9 @@ func optionsMethodHandler() func(http.Handler) http.Handler {
 }
 
 func (rb *routeBase) applyHandlers(handler http.Handler) http.Handler {
+	if rb.compression != nil {
+		handler = compressionHandler(rb.compression)(handler)
+	}
 	if rb.maxBodySize > 0 {
 		handler = maxBodySizeHandler(rb.maxBodySize)(handler)
 	}
@@ -425,16 +702,19 @@ func (rb *routeBase) applyHandlers(handler http.Handler) http.Handler {
 	if len(rb.methods) > 0 {
 		handler = limitMethodsHandler(rb.methods)(handler)
 	}
-	if rb.originAny || len(rb.originIncludes) > 0 || len(rb.originExcludes) > 0 {
-		handler = originMethodHandler(rb.originAny, rb.originIncludes, rb.originExcludes)(handler)
+	if rb.hsts != "" || len(rb.responseHeaders) > 0 {
+		handler = responseHeadersHandler(rb.hsts, rb.responseHeaders)(handler)
+	}
+	if !rb.ownOptions {
+		handler = optionsMethodHandler()(handler)
 	}
-	if rb.headers != "" {
-		handler = allowsHeadersHandler(rb.headers)(handler)
+	if rb.corsEnabled() {
+		handler = corsHandler(rb)(handler)
 	}
-	if rb.allowCredentials {
-		handler = allowCredentialsHandler()(handler)
+	if len(rb.allowCidrs) > 0 || len(rb.denyCidrs) > 0 {
+		handler = clientAddressHandler(rb.allowCidrs, rb.denyCidrs)(handler)
 	}
-	return optionsMethodHandler()(handler)
+	return handler
 }
 
 func handleRoute(router *http.ServeMux, ri *routeInfo, handler http.Handler) {
@@ -0,0 +1,50 @@
+package main
+
+import "testing"
+
+func TestParseHSTS(t *testing.T) {
+	tests := []struct {
+		hsts   string
+		header string
+		fail   bool
+	}{
+		{"31536000", "max-age=31536000", false},
+		{"365d, includeSubDomains", "max-age=31536000; includeSubDomains", false},
+		{"63072000 includeSubDomains preload", "max-age=63072000; includeSubDomains; preload", false},
+		{"0", "max-age=0", false},
+		{"-1", "", true},
+		{"includeSubDomains", "", true},
+		{"1y", "", true},
+	}
+	for _, test := range tests {
+		header, err := parseHSTS(test.hsts)
+		if (err != nil) != test.fail {
+			t.Errorf("parseHSTS(%q): unexpected error %v", test.hsts, err)
+		} else if header != test.header {
+			t.Errorf("parseHSTS(%q): got %q, want %q", test.hsts, header, test.header)
+		}
+	}
+}
+
+func TestRouteCorsMaxAge(t *testing.T) {
+	tests := []struct {
+		maxAge  string
+		seconds int64
+		fail    bool
+	}{
+		{"600", 600, false},
+		{"10m", 600, false},
+		{"1 day", 86400, false},
+		{"-600", 0, true},
+	}
+	for _, test := range tests {
+		var rb routeBase
+		failed := false
+		validateRoutePropertiesConfig(&Route{CorsMaxAge: test.maxAge}, &rb, func(msg string) { failed = true })
+		if failed != test.fail {
+			t.Errorf("corsMaxAge %q: got failure %v, want %v", test.maxAge, failed, test.fail)
+		} else if rb.corsMaxAge != test.seconds {
+			t.Errorf("corsMaxAge %q: got %v, want %v", test.maxAge, rb.corsMaxAge, test.seconds)
+		}
+	}
+}
@@ -1 +0,0 @@
-curl -H "Authorization: OAuth <token>" "https://dialogs.yandex.net/api/v1/skills/<skill id>/sounds" > files.json
\ No newline at end of file
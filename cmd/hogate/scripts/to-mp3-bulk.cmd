@@ -1,3 +0,0 @@
-@for /R %%f in (*.mp4) do @(
-	D:\data\software\multimedia\ffmpeg\x64\bin\ffmpeg.exe -i %%f -q:a 0 -map a %%~nf.mp3
-)
\ No newline at end of file
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+func simulateUsage() {
+	fmt.Print(`Usage: simulate [options]
+
+Runs the text conversation with Yandex Dialogs skill or Alexa Home Connect skill without the platforms.
+The requests are handled in process with the configuration file, the skills act for real: home-control
+switches the devices. The users state of the tales skill is kept in memory only.
+
+Options:
+--skill <type or path>
+  Yandex Dialogs skill: the type (tales, home-status, home-control) or the route path. Default: the first configured skill
+--alexa
+  Talk to Alexa Home Connect skill instead of Yandex Dialogs skill
+--user <name>
+  The user of the linked account. Default: the account is not linked
+--script <file>
+  Replay the conversation script, the exit code is 1 if any response differs
+--record <file>
+  Write the conversation to the script file
+
+Input:
+<text>
+  Yandex Dialogs: the utterance, empty one starts the skill
+#<n>
+  Yandex Dialogs: press the button n
+launch
+  Alexa: LaunchRequest
+intent <name> [<slot>=<value> ...]
+  Alexa: IntentRequest
+end
+  Alexa: SessionEndedRequest
+/new
+  Start the new session
+/quit
+  Exit
+
+Output and the script lines:
+> the input
+< the response text or speech
+~ Yandex Dialogs TTS when it differs from the text
+#<n> the button
+? Alexa reprompt
+@ Alexa directive
+! HTTP status other than OK or the account linking request
+= the end of session
+// the comment, ignored
+`)
+}
+
+// simulator sends the input to the skill and returns the response rendered as the script lines
+type simulator interface {
+	newSession()
+	send(input string) ([]string, error)
+}
+
+func simulateAction(configFile string, args []string) int {
+	var skillName, user, script, record string
+	var alexa bool
+
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.Usage = simulateUsage
+	fs.StringVar(&skillName, "skill", "", "")
+	fs.BoolVar(&alexa, "alexa", false, "")
+	fs.StringVar(&user, "user", "", "")
+	fs.StringVar(&script, "script", "", "")
+	fs.StringVar(&record, "record", "", "")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 || (alexa && skillName != "") {
+		simulateUsage()
+		return 2
+	}
+
+	if err := loadConfig(configFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ydtStateFile = ""
+
+	var sim simulator
+	if alexa {
+		s, err := newAlexaSimulator(user)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		sim = s
+	} else {
+		skill := simulateYandexSkill(skillName)
+		if skill == nil {
+			fmt.Fprintf(os.Stderr, "Yandex Dialogs skill '%v' is not configured.\n", skillName)
+			return 2
+		}
+		s, err := newYDSimulator(skill, user)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		sim = s
+	}
+	sim.newSession()
+
+	if script != "" {
+		return simulateScript(sim, script)
+	}
+	return simulateInteractive(sim, record)
+}
+
+func simulateInteractive(sim simulator, record string) int {
+	var rec io.Writer
+	if record != "" {
+		file, err := os.Create(record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to create the script file: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		rec = file
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			break
+		}
+		input := strings.TrimSpace(scanner.Text())
+		if input == "/quit" {
+			break
+		}
+		lines, err := simulateSend(sim, input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		if rec != nil {
+			fmt.Fprintln(rec, strings.TrimSpace("> "+input))
+			for _, line := range lines {
+				fmt.Fprintln(rec, line)
+			}
+		}
+	}
+	return 0
+}
+
+// simulateScript replays the script, the response of every input is compared with the lines which follow the input
+func simulateScript(sim simulator, script string) int {
+	data, err := os.ReadFile(script)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read the script file: %v\n", err)
+		return 1
+	}
+
+	type turn struct {
+		line     int
+		input    string
+		expected []string
+	}
+	var turns []*turn
+	for i, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if strings.HasPrefix(line, ">") {
+			turns = append(turns, &turn{line: i + 1, input: strings.TrimSpace(line[1:])})
+		} else if len(turns) > 0 {
+			t := turns[len(turns)-1]
+			t.expected = append(t.expected, line)
+		} else {
+			fmt.Fprintf(os.Stderr, "%v:%v: the response without the input.\n", script, i+1)
+			return 1
+		}
+	}
+
+	failed := 0
+	for _, t := range turns {
+		lines, err := simulateSend(sim, t.input)
+		if err != nil {
+			lines = []string{"! " + err.Error()}
+		}
+		same := len(lines) == len(t.expected)
+		for i := 0; same && i < len(lines); i++ {
+			same = lines[i] == t.expected[i]
+		}
+		if !same {
+			failed++
+			fmt.Printf("%v:%v: > %v\n  expected:\n", script, t.line, t.input)
+			for _, line := range t.expected {
+				fmt.Printf("    %v\n", line)
+			}
+			fmt.Println("  actual:")
+			for _, line := range lines {
+				fmt.Printf("    %v\n", line)
+			}
+		}
+	}
+
+	fmt.Printf("%v: %v turns, %v failed\n", script, len(turns), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func simulateSend(sim simulator, input string) ([]string, error) {
+	if input == "/new" {
+		sim.newSession()
+		return nil, nil
+	}
+	return sim.send(input)
+}
+
+// simulateCall passes the request to the handler and returns the response body, nil if the status is not OK
+func simulateCall(handler http.Handler, r *http.Request) ([]byte, []string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		return nil, []string{fmt.Sprintf("! %v %v", w.Code, http.StatusText(w.Code))}
+	}
+	return w.Body.Bytes(), nil
+}
+
+func simulateToken(user string, scope []string) (string, error) {
+	if user == "" {
+		return "", nil
+	}
+	scopes := make(scopeSet)
+	for _, s := range scope {
+		scopes[s] = struct{}{}
+	}
+	token, err := createAuthToken(authTokenAccess, appName, user, scopes)
+	if err != nil {
+		return "", fmt.Errorf("unable to create the access token: %v", err)
+	}
+	return token, nil
+}
+
+func simulateSessionID() string {
+	return randomString(32, []rune("0123456789abcdef"))
+}
+
+// simulateYandexSkill finds the skill by the type or the path, the first one if the name is empty
+func simulateYandexSkill(name string) *ydSkill {
+	if len(ydSkills) <= 0 {
+		if name == "" || strings.EqualFold(name, "tales") {
+			return ydDefaultSkill()
+		}
+		return nil
+	}
+	for _, skill := range ydSkills {
+		if name == "" || strings.EqualFold(name, skill.kind) || name == skill.path {
+			return skill
+		}
+	}
+	return nil
+}
+
+type ydSimulator struct {
+	handler   http.Handler
+	path      string
+	token     string
+	skillID   string
+	userID    string
+	sessionID string
+	messageID int
+	session   map[string]string
+	user      map[string]interface{}
+	buttons   []YandexDialogsButton
+}
+
+func newYDSimulator(skill *ydSkill, user string) (*ydSimulator, error) {
+	token, err := simulateToken(user, skill.scope)
+	if err != nil {
+		return nil, err
+	}
+	s := &ydSimulator{
+		handler: yandexDialogsHandler(skill),
+		path:    skill.path,
+		token:   token,
+		skillID: appName,
+		userID:  appName,
+		user:    make(map[string]interface{}),
+	}
+	if s.path == "" {
+		s.path = "/"
+	}
+	if len(skill.skillIDs) > 0 {
+		var ids []string
+		for id := range skill.skillIDs {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		s.skillID = ids[0]
+	}
+	if user != "" {
+		s.userID += "-" + user
+	}
+	return s, nil
+}
+
+func (s *ydSimulator) newSession() {
+	s.sessionID = simulateSessionID()
+	s.messageID = 0
+	s.session = nil
+	s.buttons = nil
+}
+
+func (s *ydSimulator) send(input string) ([]string, error) {
+	request := &YandexDialogsRequest{Type: "SimpleUtterance"}
+	if n, err := strconv.Atoi(strings.TrimPrefix(input, "#")); err == nil && strings.HasPrefix(input, "#") {
+		if n < 1 || n > len(s.buttons) {
+			return nil, fmt.Errorf("no button %v", n)
+		}
+		button := s.buttons[n-1]
+		input = button.Title
+		request.Payload = button.Payload
+		if button.Payload != nil && !button.Hide {
+			request.Type = "ButtonPressed"
+		}
+	}
+	request.OriginalUtterance = input
+	request.Nlu = ydSimulateNlu(input)
+	request.Command = strings.Join(request.Nlu.Tokens, " ")
+
+	env := YandexDialogsRequestEnvelope{
+		Meta: &YandexDialogsMeta{
+			Locale:     "ru-RU",
+			Timezone:   "UTC",
+			ClientID:   appName,
+			Interfaces: map[string]interface{}{"screen": struct{}{}},
+		},
+		Request: request,
+		Session: YandexDialogsRequestSession{
+			New:       s.messageID == 0,
+			MessageID: s.messageID,
+			SessionID: s.sessionID,
+			SkillID:   s.skillID,
+			UserID:    s.userID,
+		},
+		State: &YandexDialogsRequestState{
+			Session: s.session,
+			User:    s.user,
+		},
+		Version: "1.0",
+	}
+	s.messageID++
+
+	body, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	r := httptest.NewRequest(http.MethodPost, s.path, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		r.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	data, lines := simulateCall(s.handler, r)
+	if data == nil {
+		return lines, nil
+	}
+
+	var resp YandexDialogsResponseEnvelope
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+
+	s.session = nil
+	if resp.SessionState != nil {
+		if b, err := json.Marshal(resp.SessionState); err == nil {
+			json.Unmarshal(b, &s.session)
+		}
+	}
+	if update, ok := resp.UserState.(map[string]interface{}); ok {
+		for k, v := range update {
+			if v == nil {
+				delete(s.user, k)
+			} else {
+				s.user[k] = v
+			}
+		}
+	}
+
+	s.buttons = nil
+	if resp.AccountLinking != nil {
+		lines = append(lines, "! account linking")
+	}
+	if resp.Response != nil {
+		for _, line := range strings.Split(resp.Response.Text, "\n") {
+			lines = append(lines, strings.TrimRightFunc("< "+line, unicode.IsSpace))
+		}
+		if resp.Response.TTS != "" && resp.Response.TTS != resp.Response.Text {
+			lines = append(lines, "~ "+resp.Response.TTS)
+		}
+		s.buttons = resp.Response.Buttons
+		for i, button := range s.buttons {
+			lines = append(lines, fmt.Sprintf("#%v %v", i+1, button.Title))
+		}
+		if resp.Response.EndSession {
+			lines = append(lines, "= end of session")
+			s.newSession()
+		}
+	}
+	return lines, nil
+}
+
+// ydSimulateNlu splits the utterance into the tokens like Yandex Dialogs does, the numbers are recognized as YANDEX.NUMBER entities
+func ydSimulateNlu(input string) *YandexDialogsNlu {
+	nlu := &YandexDialogsNlu{Entities: []YandexDialogsEntity{}}
+	for _, t := range strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	}) {
+		// the ordinal like 2-ая is the number followed by the ending
+		if n, ending, ok := strings.Cut(t, "-"); ok && n != "" && ending != "" && strings.IndexFunc(n, func(r rune) bool { return !unicode.IsDigit(r) }) < 0 {
+			nlu.Tokens = append(nlu.Tokens, n, ending)
+		} else {
+			nlu.Tokens = append(nlu.Tokens, t)
+		}
+	}
+	for i, t := range nlu.Tokens {
+		if n, err := strconv.ParseFloat(t, 64); err == nil {
+			nlu.Entities = append(nlu.Entities, YandexDialogsEntity{
+				Tokens: YandexDialogsEntityTokens{Start: i, End: i + 1},
+				Type:   ydYandexNumber,
+				Value:  n,
+			})
+		}
+	}
+	return nlu
+}
+
+type alexaSimulator struct {
+	key        *ecdsa.PrivateKey
+	token      string
+	userID     string
+	sessionID  string
+	requests   int
+	attributes map[string]string
+}
+
+func newAlexaSimulator(user string) (*alexaSimulator, error) {
+	token, err := simulateToken(user, []string{scopeYandexHome})
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate the signing key: %v", err)
+	}
+	alexaSimulatorKey = &key.PublicKey
+
+	s := &alexaSimulator{
+		key:    key,
+		token:  token,
+		userID: "amzn1.ask.account." + appName,
+	}
+	if user != "" {
+		s.userID += "-" + user
+	}
+	return s, nil
+}
+
+func (s *alexaSimulator) newSession() {
+	s.sessionID = "amzn1.echo-api.session." + simulateSessionID()
+	s.requests = 0
+	s.attributes = nil
+}
+
+func (s *alexaSimulator) send(input string) ([]string, error) {
+	base := AlexaBaseRequest{
+		SrcRequestID: "amzn1.echo-api.request." + simulateSessionID(),
+		SrcTimestamp: time.Now().UTC().Format(time.RFC3339),
+		SrcLocale:    "en-US",
+	}
+
+	var request AlexaRequest
+	fields := strings.Fields(input)
+	switch {
+	case len(fields) == 1 && fields[0] == "launch":
+		base.SrcType = "LaunchRequest"
+		request = &AlexaLaunchRequest{AlexaBaseRequest: base}
+	case len(fields) >= 2 && fields[0] == "intent":
+		base.SrcType = "IntentRequest"
+		intent := &AlexaIntent{Name: fields[1], ConfirmationStatus: "NONE"}
+		for _, f := range fields[2:] {
+			name, value, ok := strings.Cut(f, "=")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("invalid slot '%v', use <slot>=<value>", f)
+			}
+			if intent.Slots == nil {
+				intent.Slots = make(map[string]*AlexaSlot)
+			}
+			intent.Slots[name] = &AlexaSlot{Name: name, Value: value, ConfirmationStatus: "NONE"}
+		}
+		request = &AlexaIntentRequest{AlexaBaseRequest: base, DialogState: "COMPLETED", Intent: intent}
+	case len(fields) == 1 && fields[0] == "end":
+		base.SrcType = "SessionEndedRequest"
+		request = &AlexaSessionEndedRequest{AlexaBaseRequest: base, Reason: "USER_INITIATED"}
+	default:
+		return nil, fmt.Errorf("unknown request, use launch, intent <name> [<slot>=<value> ...] or end")
+	}
+
+	application := &AlexaApplication{ApplicationId: "amzn1.ask.skill." + appName}
+	user := &AlexaUser{UserId: s.userID, AccessToken: s.token}
+	env := AlexaRequestEnvelope{
+		Version: "1.0",
+		Session: &AlexaSession{
+			New:         s.requests == 0,
+			SessionId:   s.sessionID,
+			Attributes:  s.attributes,
+			Application: application,
+			User:        user,
+		},
+		Context: &AlexaContext{
+			System: &AlexaSystem{
+				Application: application,
+				User:        user,
+			},
+		},
+		Request: request,
+	}
+	s.requests++
+
+	body, err := json.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(body)
+	signature, err := ecdsa.SignASN1(rand.Reader, s.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	r := httptest.NewRequest(http.MethodPost, dedicatedRoutes[routeAmazonAlexaHomeConnect].path, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("SignatureCertChainUrl", alexaSimulatorCertURL)
+	r.Header.Set("Signature-256", base64.StdEncoding.EncodeToString(signature))
+
+	data, lines := simulateCall(http.HandlerFunc(routeAmazonAlexaHomeConnectHandle), r)
+	if data == nil {
+		return lines, nil
+	}
+
+	var resp struct {
+		SessionAttributes map[string]string `json:"sessionAttributes"`
+		Response          *struct {
+			OutputSpeeech    *AlexaOutputSpeeech `json:"outputSpeech"`
+			Reprompt         *AlexaReprompt      `json:"reprompt"`
+			ShouldEndSession *bool               `json:"shouldEndSession"`
+			Directives       []struct {
+				Type      string          `json:"type"`
+				AudioItem *AlexaAudioItem `json:"audioItem"`
+			} `json:"directives"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+
+	s.attributes = resp.SessionAttributes
+	if resp.Response != nil {
+		if line := alexaSimulateSpeech(resp.Response.OutputSpeeech); line != "" {
+			lines = append(lines, "< "+line)
+		}
+		if resp.Response.Reprompt != nil {
+			if line := alexaSimulateSpeech(resp.Response.Reprompt.OutputSpeeech); line != "" {
+				lines = append(lines, "? "+line)
+			}
+		}
+		for _, d := range resp.Response.Directives {
+			line := "@ " + d.Type
+			if d.AudioItem != nil && d.AudioItem.Stream != nil {
+				line += " " + d.AudioItem.Stream.URL
+			}
+			lines = append(lines, line)
+		}
+		if resp.Response.ShouldEndSession != nil && *resp.Response.ShouldEndSession {
+			lines = append(lines, "= end of session")
+			s.newSession()
+		}
+	}
+	if _, ok := request.(*AlexaSessionEndedRequest); ok {
+		s.newSession()
+	}
+	return lines, nil
+}
+
+func alexaSimulateSpeech(speech *AlexaOutputSpeeech) string {
+	if speech == nil {
+		return ""
+	}
+	if speech.Type == Alexa_OutputSpeech_SSML {
+		return speech.SSML
+	}
+	return speech.Text
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestSimulateTalesScript(t *testing.T) {
+	ydtLoadTestTales(t)
+	ydtStateFile = ""
+
+	sim, err := newYDSimulator(&ydSkill{kind: "tales", handler: ydtSkill{}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.newSession()
+	if code := simulateScript(sim, "testdata/simulate/tales.script"); code != 0 {
+		t.Errorf("simulateScript: got %v, want 0", code)
+	}
+}
+
+func TestYDSimulateNlu(t *testing.T) {
+	nlu := ydSimulateNlu("Включи 2-ая, гуси-лебеди!")
+	want := []string{"включи", "2", "ая", "гуси-лебеди"}
+	if len(nlu.Tokens) != len(want) {
+		t.Fatalf("tokens: got %q, want %q", nlu.Tokens, want)
+	}
+	for i := range want {
+		if nlu.Tokens[i] != want[i] {
+			t.Fatalf("tokens: got %q, want %q", nlu.Tokens, want)
+		}
+	}
+	if len(nlu.Entities) != 1 || nlu.Entities[0].Tokens.Start != 1 || nlu.Entities[0].Value != 2.0 {
+		t.Errorf("entities: got %+v", nlu.Entities)
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"archive/zip"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net/http"
 	"os"
@@ -68,6 +70,17 @@ func parseTimeDuration(duration string) (time.Duration, error) {
 	return time.Duration(v), err
 }
 
+// parseMaxAge parses the duration where the number without suffix is the number of seconds, as in max-age of HTTP headers
+func parseMaxAge(maxAge string) (time.Duration, error) {
+	if v, err := strconv.ParseFloat(strings.TrimSpace(maxAge), 64); err == nil {
+		if math.IsNaN(v) || math.Abs(v) >= float64(math.MaxInt64/time.Second) {
+			return 0, fmt.Errorf("value out of range")
+		}
+		return time.Duration(v * float64(time.Second)), nil
+	}
+	return parseTimeDuration(maxAge)
+}
+
 type fileToArchive struct {
 	name, path string
 }
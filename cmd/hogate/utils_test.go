@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMaxAge(t *testing.T) {
+	tests := []struct {
+		value  string
+		maxAge time.Duration
+		fail   bool
+	}{
+		{"", 0, false},
+		{"0", 0, false},
+		{"31536000", 365 * 24 * time.Hour, false},
+		{" 600 ", 10 * time.Minute, false},
+		{"-5", -5 * time.Second, false},
+		{"1.5", 1500 * time.Millisecond, false},
+		{"10m", 10 * time.Minute, false},
+		{"365d", 365 * 24 * time.Hour, false},
+		{"1 hour", time.Hour, false},
+		{"99999999999999999", 0, true},
+		{"forever", 0, true},
+	}
+	for _, test := range tests {
+		maxAge, err := parseMaxAge(test.value)
+		if (err != nil) != test.fail {
+			t.Errorf("parseMaxAge(%q): unexpected error %v", test.value, err)
+		} else if !test.fail && maxAge != test.maxAge {
+			t.Errorf("parseMaxAge(%q): got %v, want %v", test.value, maxAge, test.maxAge)
+		}
+	}
+}
@@ -1,7 +1,380 @@
 package main
 
-import "net/http"
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ydSkillHandler is implemented by the skills, the session state is kept by Yandex Dialogs as the string encoded by the skill
+type ydSkillHandler interface {
+	decodeState(s string) (interface{}, error)
+	encodeState(state interface{}) (string, error)
+	// reply fills the response and returns the new session state; claim is nil when the account is not linked
+	reply(req *YandexDialogsRequestEnvelope, resp *YandexDialogsResponseEnvelope, claim *AuthTokenClaims, state interface{}) interface{}
+}
+
+type ydSkillType struct {
+	newHandler func() ydSkillHandler
+	scope      string // default scope
+}
+
+var ydSkillTypes = map[string]ydSkillType{
+	"tales":        {func() ydSkillHandler { return ydtSkill{} }, scopeYandexDialogs},
+	"home-status":  {func() ydSkillHandler { return ydhSkill{} }, scopeYandexHome},
+	"home-control": {func() ydSkillHandler { return ydcSkill{} }, scopeYandexHome},
+}
+
+type ydSkill struct {
+	routeInfo
+	kind           string // the key of ydSkillTypes
+	scope          []string
+	accountLinking bool
+	skillIDs       map[string]struct{} // any skill ID if empty
+	secret         bool                // the route has the secret path segment
+	handler        ydSkillHandler
+	replay         ydReplayGuard
+}
+
+const ydSessionTTL = time.Hour
+const ydMaxTrackedSessions = 10000
+
+// ydReplayGuard tracks the last message of the sessions, the message with the same or lower ID is the replay
+type ydReplayGuard struct {
+	lock     sync.Mutex
+	sessions map[string]ydSessionTrack
+}
+
+type ydSessionTrack struct {
+	messageID int
+	seen      time.Time
+}
+
+var ydSkills []*ydSkill
+
+func newYandexDialogsSkillRoute(path string) routeInfo {
+	return routeInfo{
+		path: path,
+		routeBase: routeBase{
+			rateLimit:   1000,
+			rateBurst:   300,
+			maxBodySize: 102400,
+			methods:     []string{"POST", "OPTIONS"},
+		},
+	}
+}
+
+func validateYandexDialogsConfig(cfgError configError) {
+	ydSkills = nil
+	if config.YandexDialogs == nil {
+		return
+	}
+
+	paths := make(map[string]struct{})
+	for _, ri := range dedicatedRoutes {
+		paths[ri.path] = struct{}{}
+	}
+
+	if len(config.YandexDialogs.Skills) <= 0 {
+		// the tales skill on the dedicated route
+		skill := ydDefaultSkill()
+		skill.routeInfo = *dedicatedRoutes[routeYandexDialogsTales]
+		skill.protect(nil, "", func(msg string) { cfgError("yandexDialogs: " + msg) })
+		ydSkills = append(ydSkills, skill)
+		return
+	}
+
+	for i, sk := range config.YandexDialogs.Skills {
+		skillError := func(msg string) {
+			cfgError(fmt.Sprintf("yandexDialogs.skills, skill %v: %v", i, msg))
+		}
+
+		skillType, ok := ydSkillTypes[strings.ToLower(sk.Type)]
+		if !ok {
+			skillError(fmt.Sprintf("unknown type '%v'.", sk.Type))
+			continue
+		}
+		path, err := parseRoutePath(sk.Path)
+		if err != nil || sk.Path == "" {
+			skillError(fmt.Sprintf("invalid path '%v'.", sk.Path))
+			continue
+		}
+		if _, ok := paths[path]; ok {
+			skillError(fmt.Sprintf("path '%v' is in use already.", path))
+			continue
+		}
+		paths[path] = struct{}{}
+
+		skill := &ydSkill{
+			routeInfo:      newYandexDialogsSkillRoute(path),
+			kind:           strings.ToLower(sk.Type),
+			accountLinking: sk.AccountLinking == nil || *sk.AccountLinking,
+			handler:        skillType.newHandler(),
+		}
+		validateRoutePropertiesConfig(sk, &skill.routeBase, skillError)
+
+		scope := sk.Scope
+		if scope == "" {
+			scope = skillType.scope
+		}
+		for k := range parseScope(scope) {
+			skill.scope = append(skill.scope, k)
+		}
+		skill.protect(sk.SkillIDs, sk.Secret, skillError)
+		if _, ok := paths[skill.path]; ok && skill.path != path {
+			skillError(fmt.Sprintf("path '%v' is in use already.", skill.path))
+			continue
+		}
+		paths[skill.path] = struct{}{}
+		ydSkills = append(ydSkills, skill)
+	}
+}
+
+// ydDefaultSkill returns the tales skill served on the dedicated route when no skills are configured
+func ydDefaultSkill() *ydSkill {
+	return &ydSkill{kind: "tales", scope: []string{scopeYandexDialogs}, accountLinking: true, handler: ydtSkill{}}
+}
+
+// protect sets the allowed skill IDs and appends the secret to the path, the common settings are used if not set
+func (skill *ydSkill) protect(skillIDs []string, secret string, cfgError configError) {
+	if len(skillIDs) <= 0 {
+		skillIDs = config.YandexDialogs.SkillIDs
+	}
+	if secret == "" {
+		secret = config.YandexDialogs.Secret
+	}
+	if len(skillIDs) > 0 {
+		skill.skillIDs = make(map[string]struct{}, len(skillIDs))
+		for _, id := range skillIDs {
+			skill.skillIDs[id] = struct{}{}
+		}
+	}
+	if secret != "" {
+		if strings.Contains(secret, "/") || url.PathEscape(secret) != secret {
+			cfgError("secret must be the valid path segment.")
+			return
+		}
+		skill.path = strings.TrimSuffix(skill.path, "/") + "/" + secret
+		skill.secret = true
+	}
+}
+
+// accept tells if the request is not the replay of the seen one, the oldest session is forgotten when too many are tracked
+func (g *ydReplayGuard) accept(skillID, sessionID string, messageID int) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	now := time.Now()
+	key := skillID + "/" + sessionID
+	if t, ok := g.sessions[key]; ok && now.Sub(t.seen) < ydSessionTTL && messageID <= t.messageID {
+		return false
+	}
+	if g.sessions == nil {
+		g.sessions = make(map[string]ydSessionTrack)
+	} else if _, ok := g.sessions[key]; !ok && len(g.sessions) >= ydMaxTrackedSessions {
+		for k, t := range g.sessions {
+			if now.Sub(t.seen) >= ydSessionTTL {
+				delete(g.sessions, k)
+			}
+		}
+		if len(g.sessions) >= ydMaxTrackedSessions {
+			oldest, oldestSeen := "", now
+			for k, t := range g.sessions {
+				if t.seen.Before(oldestSeen) || oldest == "" {
+					oldest, oldestSeen = k, t.seen
+				}
+			}
+			delete(g.sessions, oldest)
+		}
+	}
+	g.sessions[key] = ydSessionTrack{messageID: messageID, seen: now}
+	return true
+}
 
 func addYandexDialogsRoutes(router *http.ServeMux) {
-	handleDedicatedRoute(router, routeYandexDialogsTales, http.HandlerFunc(yandexDialogsTales))
+	if len(ydSkills) <= 0 {
+		// the tales skill on the dedicated route unless the skills are configured
+		handleDedicatedRoute(router, routeYandexDialogsTales, yandexDialogsHandler(ydDefaultSkill()))
+		return
+	}
+	for _, skill := range ydSkills {
+		handleRoute(router, &skill.routeInfo, yandexDialogsHandler(skill))
+	}
+}
+
+// yandexDialogsHandler handles the protocol part common for all the skills: the request envelope, the account linking and the session state
+func yandexDialogsHandler(skill *ydSkill) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req YandexDialogsRequestEnvelope
+		if !parseJSONRequest(&req, w, r) || req.Version != "1.0" {
+			return
+		}
+
+		resp := YandexDialogsResponseEnvelope{
+			Response: &YandexDialogsResponse{},
+			Session: YandexDialogsResponseSession{
+				SessionID: req.Session.SessionID,
+				MessageID: req.Session.MessageID,
+				UserID:    req.Session.UserID,
+			},
+			Version: "1.0",
+		}
+
+		// the replay guard tracks only the sessions of the allowed skills
+		reject := ""
+		if _, ok := skill.skillIDs[req.Session.SkillID]; !ok && len(skill.skillIDs) > 0 {
+			reject = "skill"
+		} else if req.Session.SessionID == "" || !skill.replay.accept(req.Session.SkillID, req.Session.SessionID, req.Session.MessageID) {
+			reject = "replay"
+		}
+		if reject != "" {
+			httpSetLogBulkData(r, logData{
+				"yandexDialogs": {"reject": reject, "skill": req.Session.SkillID},
+			})
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// the test command is answered without authorization only when the foreign callers are rejected
+		if req.Request != nil && req.Request.Command == "test" && (len(skill.skillIDs) > 0 || skill.secret) {
+
+			resp.Response.Text = req.Request.Command
+
+		} else if status, claim := testAuthorization(r, skill.scope...); skill.accountLinking && status != http.StatusOK {
+
+			resp.Response.Text = "пожалуйста авторизируйтесь"
+			resp.AccountLinking = &struct{}{}
+
+		} else {
+			if status != http.StatusOK {
+				claim = nil
+			}
+			state := skill.getSession(req.State)
+			state = skill.handler.reply(&req, &resp, claim, state)
+			resp.SessionState = skill.setSession(state)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+func (skill *ydSkill) getSession(state *YandexDialogsRequestState) interface{} {
+	if state != nil {
+		if s, ok := state.Session["value"]; ok {
+			if st, err := skill.handler.decodeState(s); err == nil {
+				return st
+			}
+		}
+	}
+	return nil
+}
+
+func (skill *ydSkill) setSession(state interface{}) interface{} {
+	if state != nil {
+		if s, err := skill.handler.encodeState(state); err == nil && s != "" {
+			return map[string]string{
+				"value": s,
+			}
+		}
+	}
+	return nil
+}
+
+// ydIntent is matched when the tokens contain a word of every group
+type ydIntent struct {
+	name   string
+	groups []map[string]struct{}
+}
+
+// newYDIntent creates the intent, every group is the space separated list of words
+func newYDIntent(name string, groups ...string) ydIntent {
+	intent := ydIntent{name: name}
+	for _, g := range groups {
+		words := make(map[string]struct{})
+		for _, w := range strings.Fields(g) {
+			words[w] = struct{}{}
+		}
+		intent.groups = append(intent.groups, words)
+	}
+	return intent
+}
+
+// ydMatchIntent returns the name of the first matched intent and the tokens not used by it
+func ydMatchIntent(tokens []string, intents []ydIntent) (string, []string) {
+	for _, intent := range intents {
+		used := make([]bool, len(tokens))
+		matched := true
+		for _, group := range intent.groups {
+			found := false
+			for i, t := range tokens {
+				if _, ok := group[strings.ToLower(t)]; ok {
+					used[i], found = true, true
+				}
+			}
+			if !found {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			var rest []string
+			for i, t := range tokens {
+				if !used[i] {
+					rest = append(rest, strings.ToLower(t))
+				}
+			}
+			return intent.name, rest
+		}
+	}
+	return "", tokens
+}
+
+// ydEntitiesByToken returns the entities by the index of the first token, the longest one if several entities start at the same token
+func ydEntitiesByToken(nlu *YandexDialogsNlu) map[int]YandexDialogsEntity {
+	entities := make(map[int]YandexDialogsEntity)
+	if nlu == nil {
+		return entities
+	}
+	for _, e := range nlu.Entities {
+		if e.Tokens.Start < 0 || e.Tokens.End <= e.Tokens.Start || e.Tokens.End > len(nlu.Tokens) {
+			continue
+		}
+		if c, ok := entities[e.Tokens.Start]; ok && c.Tokens.End >= e.Tokens.End {
+			continue
+		}
+		entities[e.Tokens.Start] = e
+	}
+	return entities
+}
+
+// number returns the value of YANDEX.NUMBER entity
+func (e YandexDialogsEntity) number() (float64, bool) {
+	if e.Type != ydYandexNumber {
+		return 0, false
+	}
+	n, ok := e.Value.(float64)
+	return n, ok
+}
+
+// dateTime returns the value of YANDEX.DATETIME entity
+func (e YandexDialogsEntity) dateTime() (YandexDialogsEntityDateTime, bool) {
+	var dt YandexDialogsEntityDateTime
+	if e.Type != ydYandexDateTime || e.Value == nil {
+		return dt, false
+	}
+	b, err := json.Marshal(e.Value)
+	if err == nil {
+		err = json.Unmarshal(b, &dt)
+	}
+	return dt, err == nil
+}
+
+// hasDate tells if the date is set, not only the time
+func (dt YandexDialogsEntityDateTime) hasDate() bool {
+	return dt.Year != 0 || dt.YearIsRelative || dt.Month != 0 || dt.MonthIsRelative || dt.Day != 0 || dt.DayIsRelative
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	ydcIntentOn  = "on"
+	ydcIntentOff = "off"
+)
+
+var ydcIntents = []ydIntent{
+	newYDIntent(ydcIntentOn, "включи включить включите зажги зажечь"),
+	newYDIntent(ydcIntentOff, "выключи выключить выключите погаси погасить потуши"),
+}
+
+var ydcwmAll = map[string]struct{}{
+	"все": {}, "всё": {}, "везде": {}, "всех": {},
+}
+
+var ydcwmCancel = map[string]struct{}{
+	"отмена": {}, "отмени": {}, "хватит": {}, "никакой": {}, "никакое": {}, "ничего": {}, "стоп": {},
+}
+
+// ydcPending is the action waiting for the device choice
+type ydcPending struct {
+	On      bool     `json:"on"`
+	Devices []string `json:"devices,omitempty"`
+}
+
+// ydcButtonPayload is the payload of the device choice button
+type ydcButtonPayload struct {
+	Device string `json:"device"`
+}
+
+// ydcSkill is the home control skill, it turns yandexHome devices on and off
+type ydcSkill struct{}
+
+func (ydcSkill) decodeState(s string) (interface{}, error) {
+	var p ydcPending
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (ydcSkill) encodeState(state interface{}) (string, error) {
+	b, err := json.Marshal(state)
+	return string(b), err
+}
+
+func (ydcSkill) reply(req *YandexDialogsRequestEnvelope, resp *YandexDialogsResponseEnvelope, claim *AuthTokenClaims, state interface{}) interface{} {
+	r := resp.Response
+	if claim == nil {
+		// the devices are not controlled anonymously even if the skill doesn't require the linked account
+		r.Text = "пожалуйста авторизируйтесь"
+		resp.AccountLinking = &struct{}{}
+		return nil
+	}
+	visible := yxhDeviceAccess(claim)
+
+	var tokens []string
+	if req.Request != nil && req.Request.Nlu != nil {
+		tokens = req.Request.Nlu.Tokens
+	}
+	all := false
+	for _, t := range tokens {
+		t = strings.ToLower(t)
+		if _, ok := ydcwmAll[t]; ok {
+			all = true
+		}
+		if _, ok := ydcwmCancel[t]; ok && state != nil {
+			r.Text = "Хорошо, ничего не трогаю."
+			return nil
+		}
+	}
+
+	intent, rest := ydMatchIntent(tokens, ydcIntents)
+	if intent == "" {
+		pending, ok := state.(ydcPending)
+		if !ok {
+			r.Text = "Скажите, например, включи свет в гараже."
+			return nil
+		}
+		// the answer to the device choice
+		candidates := ydcDevices(pending.Devices, visible)
+		if req.Request != nil && req.Request.Payload != nil {
+			var payload ydcButtonPayload
+			if b, err := json.Marshal(req.Request.Payload); err == nil && json.Unmarshal(b, &payload) == nil {
+				candidates = ydcDevices([]string{payload.Device}, func(d yxhDevice) bool { return visible(d) && ydcContains(pending.Devices, d.id) })
+				all = true
+			}
+		} else if !all {
+			candidates = ydcFilter(ydhFindDevices(ydhDeviceWords(rest), visible), pending.Devices)
+		}
+		return ydcApply(r, pending.On, candidates, all)
+	}
+
+	var devices []yxhDevice
+	if words := ydhDeviceWords(rest); len(words) > 0 {
+		for _, d := range ydhFindDevices(words, visible) {
+			if d.validate(yxhCapabilityOnOff, 0) {
+				devices = append(devices, d)
+			}
+		}
+	}
+	return ydcApply(r, intent == ydcIntentOn, devices, all)
+}
+
+// ydcApply turns the devices on or off, asks to choose the device if there are several of them
+func ydcApply(r *YandexDialogsResponse, on bool, devices []yxhDevice, all bool) interface{} {
+	if len(devices) <= 0 {
+		r.Text = "Не нашла такого устройства, повторите пожалуйста."
+		return nil
+	}
+	if len(devices) > 1 && !all {
+		pending := ydcPending{On: on}
+		if on {
+			r.Text = "Что включить?"
+		} else {
+			r.Text = "Что выключить?"
+		}
+		for _, d := range devices {
+			pending.Devices = append(pending.Devices, d.id)
+			r.Buttons = append(r.Buttons, YandexDialogsButton{Title: ydhDeviceName(d), Payload: ydcButtonPayload{Device: d.id}})
+		}
+		r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "Все"})
+		r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "Отмена"})
+		return pending
+	}
+
+	var done, failed []string
+	for _, d := range devices {
+		result := d.action([]YandexHomeCapabilityAction{{
+			Type:  yhDeviceCapOnOff,
+			State: YandexHomeAction{Instance: "on", Value: on},
+		}})
+		if len(result.Capabilities) > 0 && result.Capabilities[0].State.ActionResult.Status == yhDeviceStatusDone {
+			done = append(done, ydhDeviceName(d))
+		} else {
+			failed = append(failed, ydhDeviceName(d))
+		}
+	}
+
+	var bt strings.Builder
+	if len(done) > 0 {
+		if on {
+			bt.WriteString("Включила: ")
+		} else {
+			bt.WriteString("Выключила: ")
+		}
+		bt.WriteString(strings.Join(done, ", "))
+		bt.WriteString(".")
+	}
+	if len(failed) > 0 {
+		if bt.Len() > 0 {
+			bt.WriteString(" ")
+		}
+		bt.WriteString("Не получилось: ")
+		bt.WriteString(strings.Join(failed, ", "))
+		bt.WriteString(".")
+	}
+	r.Text = bt.String()
+	return nil
+}
+
+func ydcDevices(ids []string, visible func(d yxhDevice) bool) []yxhDevice {
+	var devices []yxhDevice
+	for _, id := range ids {
+		if d, ok := yxhDevices[id]; ok && visible(d) {
+			devices = append(devices, d)
+		}
+	}
+	return devices
+}
+
+func ydcFilter(devices []yxhDevice, ids []string) []yxhDevice {
+	var rv []yxhDevice
+	for _, d := range devices {
+		if ydcContains(ids, d.id) {
+			rv = append(rv, d)
+		}
+	}
+	return rv
+}
+
+func ydcContains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -94,7 +94,7 @@ type YandexDialogsEntityGeo struct {
 // YandexDialogsEntityDateTime struct
 type YandexDialogsEntityDateTime struct {
 	Year             int  `json:"year,omitempty"`
-	YearIsRelative   bool `json:"year_is_relative ,omitempty"`
+	YearIsRelative   bool `json:"year_is_relative,omitempty"`
 	Month            int  `json:"month,omitempty"`
 	MonthIsRelative  bool `json:"month_is_relative,omitempty"`
 	Day              int  `json:"day,omitempty"`
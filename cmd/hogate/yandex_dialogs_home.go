@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	ydhIntentTemperature = "temperature"
+	ydhIntentStatus      = "status"
+	ydhIntentDevices     = "devices"
+)
+
+var ydhIntents = []ydIntent{
+	newYDIntent(ydhIntentTemperature, "температура температуру температуры градусов градуса градус тепло холодно"),
+	newYDIntent(ydhIntentStatus, "включен включена включено включены выключен выключена выключено выключены горит горят работает работают состояние статус"),
+	newYDIntent(ydhIntentDevices, "устройства устройств устройство"),
+}
+
+// ydhwmDone end the session when said alone
+var ydhwmDone = map[string]struct{}{
+	"хватит": {}, "выйти": {}, "выйди": {}, "закончи": {}, "закончить": {}, "стоп": {},
+	"спасибо": {}, "пока": {}, "ничего": {},
+}
+
+// ydhNoiseWords are not used to find the devices
+var ydhNoiseWords = map[string]struct{}{
+	"какая": {}, "какой": {}, "какое": {}, "какие": {}, "сколько": {}, "сейчас": {}, "ли": {},
+	"в": {}, "во": {}, "на": {}, "у": {}, "есть": {}, "что": {}, "там": {}, "скажи": {}, "покажи": {},
+	"все": {}, "всё": {}, "везде": {}, "всех": {},
+}
+
+// ydhSkill is the home status skill, it answers the questions about yandexHome devices
+type ydhSkill struct{}
+
+func (ydhSkill) decodeState(s string) (interface{}, error) {
+	return nil, nil
+}
+
+func (ydhSkill) encodeState(state interface{}) (string, error) {
+	return "", nil
+}
+
+func (ydhSkill) reply(req *YandexDialogsRequestEnvelope, resp *YandexDialogsResponseEnvelope, claim *AuthTokenClaims, state interface{}) interface{} {
+	r := resp.Response
+	if claim == nil {
+		// the devices are not shown anonymously even if the skill doesn't require the linked account
+		r.Text = "пожалуйста авторизируйтесь"
+		resp.AccountLinking = &struct{}{}
+		return nil
+	}
+	if req.Request == nil || req.Request.Nlu == nil || len(req.Request.Nlu.Tokens) <= 0 {
+		r.Text = "Спросите, например, какая температура в спальне."
+		return nil
+	}
+
+	intent, rest := ydMatchIntent(req.Request.Nlu.Tokens, ydhIntents)
+	if intent == "" {
+		if _, ok := ydhwmDone[strings.ToLower(req.Request.Command)]; ok {
+			r.Text = "Пока"
+			r.EndSession = true
+			return nil
+		}
+		r.Text = "Я вас не поняла, повторите пожалуйста."
+		return nil
+	}
+
+	devices := ydhFindDevices(ydhDeviceWords(rest), yxhDeviceAccess(claim))
+	if len(devices) <= 0 {
+		r.Text = "Не нашла таких устройств."
+		return nil
+	}
+
+	var bt strings.Builder
+	switch intent {
+	case ydhIntentDevices:
+		bt.WriteString("Устройства:")
+		for _, d := range devices {
+			bt.WriteString("\n")
+			bt.WriteString(ydhDeviceName(d))
+		}
+
+	case ydhIntentTemperature:
+		for _, d := range devices {
+			units, ok := ydhTemperatureUnits(d)
+			if !ok {
+				continue
+			}
+			for _, c := range d.query().Capabilities {
+				if c.Type != yhDeviceCapRange || c.State.Instance != yhCapRangeInstanceTemperature {
+					continue
+				}
+				if v, ok := c.State.Value.(float64); ok {
+					if units == yxhUnitsKelvin {
+						v -= 273.15
+					}
+					if bt.Len() > 0 {
+						bt.WriteString(", ")
+					}
+					bt.WriteString(fmt.Sprintf("%v: %v °C", ydhDeviceName(d), math.Round(v*10)/10))
+				}
+			}
+		}
+		if bt.Len() <= 0 {
+			bt.WriteString("Не получилось узнать температуру.")
+		}
+
+	case ydhIntentStatus:
+		for _, d := range devices {
+			if bt.Len() > 0 {
+				bt.WriteString(", ")
+			}
+			bt.WriteString(ydhDeviceName(d))
+			bt.WriteString(": ")
+			status := "неизвестно"
+			q := d.query()
+			if q.ErrorCode != "" {
+				status = "недоступно"
+			}
+			for _, c := range q.Capabilities {
+				if c.Type == yhDeviceCapOnOff {
+					if on, ok := c.State.Value.(bool); ok && on {
+						status = "включено"
+					} else if ok {
+						status = "выключено"
+					}
+				}
+			}
+			bt.WriteString(status)
+		}
+	}
+
+	r.Text = bt.String()
+	return nil
+}
+
+// ydhTemperatureUnits returns the units of the retrievable temperature of the device
+func ydhTemperatureUnits(d yxhDevice) (yxhUnitsType, bool) {
+	for _, c := range d.capabilities {
+		if p, ok := c.parameters.(yxhParamRange); ok && c.retrievable && p.instance == yxhRangeTemperature {
+			return p.units, true
+		}
+	}
+	return 0, false
+}
+
+// ydhDeviceWords returns the tokens which could be the part of the room or the device name
+func ydhDeviceWords(tokens []string) []string {
+	var words []string
+	for _, t := range tokens {
+		if _, ok := ydhNoiseWords[t]; !ok {
+			words = append(words, t)
+		}
+	}
+	return words
+}
+
+// ydhFindDevices returns the visible devices with the most words similar to the room or the name, all visible devices if no words
+func ydhFindDevices(words []string, visible func(d yxhDevice) bool) []yxhDevice {
+	stems := ydtStems(words)
+
+	var devices []yxhDevice
+	best := 0
+	for _, d := range yxhDevices {
+		if !visible(d) {
+			continue
+		}
+		score := ydhMatch(stems, d.room+" "+d.name)
+		if len(stems) > 0 && (score <= 0 || score < best) {
+			continue
+		}
+		if score > best {
+			best = score
+			devices = devices[:0]
+		}
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].room != devices[j].room {
+			return devices[i].room < devices[j].room
+		}
+		return devices[i].name < devices[j].name
+	})
+	return devices
+}
+
+// ydhMatch returns the number of stems similar to the words of the text
+func ydhMatch(stems []string, text string) int {
+	keys := ydtStems([]string{text})
+	n := 0
+	for _, s := range stems {
+		for _, key := range keys {
+			if ydtSimilarity(key, s) >= ydtMatchThreshold {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+func ydhDeviceName(d yxhDevice) string {
+	if d.room != "" {
+		return d.name + " (" + d.room + ")"
+	}
+	return d.name
+}
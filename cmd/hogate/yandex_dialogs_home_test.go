@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+// ydhTestReply asks the home status skill the question
+func ydhTestReply(t *testing.T, claim *AuthTokenClaims, question string) *YandexDialogsResponseEnvelope {
+	t.Helper()
+	req := &YandexDialogsRequestEnvelope{Request: &YandexDialogsRequest{Command: question, Nlu: ydSimulateNlu(question)}}
+	resp := &YandexDialogsResponseEnvelope{Response: &YandexDialogsResponse{}}
+	ydhSkill{}.reply(req, resp, claim, nil)
+	return resp
+}
+
+func TestYDHSkillAnonymous(t *testing.T) {
+	savedDevices := yxhDevices
+	defer func() { yxhDevices = savedDevices }()
+	yxhDevices = map[string]yxhDevice{
+		"lamp": {id: "lamp", name: "Лампа", room: "Спальня", zwID: 2},
+	}
+
+	resp := ydhTestReply(t, nil, "какие есть устройства")
+	if resp.AccountLinking == nil {
+		t.Errorf("anonymous request: no account linking, got %q", resp.Response.Text)
+	}
+	resp = ydhTestReply(t, &AuthTokenClaims{UserName: "bob"}, "какие есть устройства")
+	if resp.AccountLinking != nil || resp.Response.Text != "Устройства:\nЛампа (Спальня)" {
+		t.Errorf("linked request: got %q", resp.Response.Text)
+	}
+}
+
+func TestYDSkillTypeScope(t *testing.T) {
+	for kind, scope := range map[string]string{"tales": scopeYandexDialogs, "home-status": scopeYandexHome, "home-control": scopeYandexHome} {
+		if st := ydSkillTypes[kind]; st.scope != scope {
+			t.Errorf("%v: default scope %q, want %q", kind, st.scope, scope)
+		}
+	}
+}
+
+func TestYDHSkillTemperature(t *testing.T) {
+	yxhStubZwCmd(t)
+	savedDevices := yxhDevices
+	defer func() { yxhDevices = savedDevices }()
+	yxhDevices = map[string]yxhDevice{
+		"lamp":    {id: "lamp", name: "Лампа", room: "Спальня", zwID: 2},
+		"ac":      yxhTestThermostat("ac", 3, yxhUnitsCelsius),
+		"kitchen": {id: "kitchen", name: "Кондиционер", room: "Кухня", zwID: 4, devType: yxhDeviceTypeThermostatAC, capabilities: yxhTestThermostat("", 4, yxhUnitsKelvin).capabilities},
+	}
+	claim := &AuthTokenClaims{UserName: "bob"}
+
+	tests := []struct {
+		question string
+		answer   string
+	}{
+		{"какая температура в спальне", "Кондиционер (Спальня): 21.5 °C"},
+		{"сколько градусов на кухне", "Кондиционер (Кухня): 21.5 °C"},
+		{"какая температура у лампы", "Не получилось узнать температуру."},
+	}
+	for _, test := range tests {
+		if resp := ydhTestReply(t, claim, test.question); resp.Response.Text != test.answer {
+			t.Errorf("%q: got %q, want %q", test.question, resp.Response.Text, test.answer)
+		}
+	}
+}
+
+func TestYDHSkillDone(t *testing.T) {
+	claim := &AuthTokenClaims{UserName: "bob"}
+	for _, question := range []string{"хватит", "спасибо", "пока"} {
+		if resp := ydhTestReply(t, claim, question); !resp.Response.EndSession {
+			t.Errorf("%q: got %q, the session is not ended", question, resp.Response.Text)
+		}
+	}
+}
@@ -1,10 +1,8 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"math/rand"
-	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -26,6 +24,7 @@ type yandexDialogsTalesFile struct {
 	fileType ydtFileType
 	length   int32
 	ids      []string
+	stems    []string
 }
 
 type ydtReaction uint16
@@ -41,6 +40,15 @@ const (
 	ydtReactionSelect
 	ydtReactionRandom
 	ydtReactionDone
+	ydtReactionFavoriteAdd
+	ydtReactionFavoriteRemove
+	ydtReactionFavorites
+	ydtReactionHistory
+	ydtReactionContinue
+	ydtReactionRestart
+	ydtReactionQueue
+	ydtReactionPlaylists
+	ydtReactionYes
 )
 
 var ydtFileTypes map[ydtFileType][]yandexDialogsTalesFile
@@ -49,6 +57,10 @@ var ydtRand *rand.Rand = nil
 
 const ydtDefaultSliceLength = 5
 
+const ydtDefaultPartsPerTurn = 3
+
+var ydtPartsPerTurn = ydtDefaultPartsPerTurn
+
 const ydtMaxSessions = int32(1000)
 
 type yandexDialogsTalesSession struct {
@@ -65,16 +77,30 @@ func init() {
 
 func validateYandexDialogsTalesConfig(cfgError configError) {
 	ydtFileTypes = make(map[ydtFileType][]yandexDialogsTalesFile)
-	if config.YandexDialogs == nil || config.YandexDialogs.Tales == "" {
+	ydtPlaylists = nil
+	ydtPartsPerTurn = ydtDefaultPartsPerTurn
+	if config.YandexDialogs == nil {
+		validateYandexDialogsTalesCategories(nil, cfgError)
+		return
+	}
+	if config.YandexDialogs.PartsPerTurn < 0 {
+		cfgError("yandexDialogs.partsPerTurn: negative value not allowed.")
+	} else if config.YandexDialogs.PartsPerTurn > 0 {
+		ydtPartsPerTurn = config.YandexDialogs.PartsPerTurn
+	}
+	if config.YandexDialogs.Tales == "" {
+		validateYandexDialogsTalesCategories(nil, cfgError)
 		return
 	}
-	var tales []yandexDialogsTale
-	if err := loadSubConfig(config.YandexDialogs.Tales, &tales); err != nil {
+	var catalog yandexDialogsTalesCatalog
+	if err := loadSubConfig(config.YandexDialogs.Tales, &catalog); err != nil {
+		validateYandexDialogsTalesCategories(nil, cfgError)
 		cfgError(fmt.Sprintf("yandexDialogs.tales, unable to load configuration file '%v': %v", config.YandexDialogs.Tales, err))
 		return
 	}
+	validateYandexDialogsTalesCategories(catalog.Categories, cfgError)
 
-	for i, tale := range tales {
+	for i, tale := range catalog.Tales {
 		taleError := func(msg string) {
 			cfgError(fmt.Sprintf("yandexDialogs.tales, tale %v: %v", i, msg))
 		}
@@ -100,6 +126,7 @@ func validateYandexDialogsTalesConfig(cfgError configError) {
 				}
 			}
 		}
+		file.stems = ydtStems(file.keys)
 
 		if tales, ok := ydtFileTypes[fileType]; ok {
 			ydtFileTypes[fileType] = append(tales, file)
@@ -107,151 +134,221 @@ func validateYandexDialogsTalesConfig(cfgError configError) {
 			ydtFileTypes[fileType] = []yandexDialogsTalesFile{file}
 		}
 	}
+
+	validateYandexDialogsTalesPlaylists(catalog.Playlists, cfgError)
 }
 
-func parseYandexDialogsTaleType(t string) (ydtFileType, error) {
-	switch strings.ToLower(t) {
-	case "fairytale":
-		return ydtTypeFairyTale, nil
-	case "story":
-		return ydtTypeStory, nil
-	case "song":
-		return ydtTypeSong, nil
-	case "verse":
-		return ydtTypeVerse, nil
-	case "joke":
-		return ydtTypeJoke, nil
-	}
-	return 0, fmt.Errorf("unrecognized tale type")
+// ydtSkill is the tales skill
+type ydtSkill struct{}
+
+func (ydtSkill) decodeState(s string) (interface{}, error) {
+	return decodeState(s)
 }
 
-func yandexDialogsTales(w http.ResponseWriter, r *http.Request) {
-	var req YandexDialogsRequestEnvelope
-	if !parseJSONRequest(&req, w, r) || req.Version != "1.0" {
-		return
+func (ydtSkill) encodeState(state interface{}) (string, error) {
+	return encodeState(state)
+}
+
+func (ydtSkill) reply(req *YandexDialogsRequestEnvelope, resp *YandexDialogsResponseEnvelope, claim *AuthTokenClaims, state interface{}) interface{} {
+	var yandexUserState interface{}
+	if req.State != nil {
+		yandexUserState = req.State.User
 	}
+	user := ydtUser(req.Session.UserID, yandexUserState)
 
-	resp := YandexDialogsResponseEnvelope{
-		Response: &YandexDialogsResponse{},
-		Session: YandexDialogsResponseSession{
-			SessionID: req.Session.SessionID,
-			MessageID: req.Session.MessageID,
-			UserID:    req.Session.UserID,
-		},
-		Version: "1.0",
+	if req.AccountLinking != nil {
+		req.Session.New = true
 	}
 
-	if req.Request != nil && req.Request.Command == "test" {
+	errorText := "Что-то пошло не так"
 
-		resp.Response.Text = req.Request.Command
+	reaction, reactionData := ydtReactionNone, interface{}(nil)
+	if req.Request != nil {
+		reaction, reactionData = yandexDialogsTalesReaction(*req.Request)
+	}
+	switch reaction {
+	case ydtReactionDone:
+		_, isPart := state.(yandexDialogsTalesPart)
+		_, isQueue := state.(yandexDialogsTalesQueue)
+		if _, ok := state.(yandexDialogsTalesItem); ok || isPart || isQueue {
+			state = nil
+			resp.Response.Text = "Рассказать что-нибудь еще?"
+			resp.Response.Buttons = append(resp.Response.Buttons, YandexDialogsButton{Title: "А что есть?"})
+		} else {
+			state = nil
+			resp.Response.Text = "Пока"
+			resp.Response.EndSession = true
+		}
+	case ydtReactionOverview:
+		fileType, _ := reactionData.(ydtFileType)
+		state = yandexDialogsTalesReactionOverview(resp.Response, req.Session.SkillID, fileType)
 
-	} else if status, _ := testAuthorization(r, scopeYandexDialogs); status != http.StatusOK {
+	case ydtReactionSlice:
+		if slice, ok := reactionData.(yandexDialogsTalesSlice); ok {
+			state = yandexDialogsTalesReactionSlice(resp.Response, req.Session.SkillID, slice.fileType, int(slice.index), int(slice.length))
+		} else {
+			resp.Response.Text = errorText
+		}
 
-		resp.Response.Text = "пожалуйста авторизируйтесь"
-		resp.AccountLinking = &struct{}{}
+	case ydtReactionList:
+		if list, ok := reactionData.([]yandexDialogsTalesItem); ok {
+			state = yandexDialogsTalesReactionList(resp.Response, req.Session.SkillID, list)
+		} else {
+			resp.Response.Text = errorText
+		}
 
-	} else {
-		state := yandexDialogsTalesGetSession(req.State)
+	case ydtReactionNext:
+		state = yandexDialogsTalesReactionNext(resp.Response, req.Session.SkillID, state)
 
-		if req.AccountLinking != nil {
-			req.Session.New = true
-		}
+	case ydtReactionPrevious:
+		state = yandexDialogsTalesReactionPrevious(resp.Response, req.Session.SkillID, state)
 
-		errorText := "Что-то пошло не так"
+	case ydtReactionRepeat:
+		state = yandexDialogsTalesReactionRepeat(resp.Response, req.Session.SkillID, state)
 
-		reaction, reactionData := ydtReactionNone, interface{}(nil)
-		if req.Request != nil {
-			reaction, reactionData = yandexDialogsTalesReaction(*req.Request)
+	case ydtReactionSelect:
+		if sel, ok := reactionData.(yandexDialogsTalesSelect); ok {
+			state = yandexDialogsTalesReactionSelect(resp.Response, req.Session.SkillID, sel.fileType, int(sel.index), sel.relative, state)
+		} else {
+			resp.Response.Text = errorText
 		}
-		switch reaction {
-		case ydtReactionDone:
-			if _, ok := state.(yandexDialogsTalesItem); ok {
-				state = nil
-				resp.Response.Text = "Рассказать что-нибудь еще?"
-				resp.Response.Buttons = append(resp.Response.Buttons, YandexDialogsButton{Title: "А что есть?"})
-			} else {
-				state = nil
-				resp.Response.Text = "Пока"
-				resp.Response.EndSession = true
-			}
-		case ydtReactionOverview:
-			fileType, _ := reactionData.(ydtFileType)
-			state = yandexDialogsTalesReactionOverview(resp.Response, req.Session.SkillID, fileType)
 
-		case ydtReactionSlice:
-			if slice, ok := reactionData.(yandexDialogsTalesSlice); ok {
-				state = yandexDialogsTalesReactionSlice(resp.Response, req.Session.SkillID, slice.fileType, int(slice.index), int(slice.length))
-			} else {
-				resp.Response.Text = errorText
-			}
+	case ydtReactionRandom:
+		if fileType, ok := reactionData.(ydtFileType); ok {
+			state = yandexDialogsTalesReactionRandom(resp.Response, req.Session.SkillID, fileType)
+		} else {
+			resp.Response.Text = errorText
+		}
 
-		case ydtReactionList:
-			if list, ok := reactionData.([]yandexDialogsTalesItem); ok {
-				state = yandexDialogsTalesReactionList(resp.Response, req.Session.SkillID, list)
+	case ydtReactionFavoriteAdd, ydtReactionFavoriteRemove:
+		item, ok := state.(yandexDialogsTalesItem)
+		if part, isPart := state.(yandexDialogsTalesPart); isPart {
+			item, ok = part.yandexDialogsTalesItem, true
+		} else if queue, isQueue := state.(yandexDialogsTalesQueue); isQueue && int(queue.current) < len(queue.items) {
+			item, ok = queue.items[queue.current], true
+		}
+		if !ok {
+			item, _, ok = user.last()
+		}
+		if name := ydtTaleName(item); ok && name != "" {
+			if reaction == ydtReactionFavoriteAdd {
+				resp.UserState = ydtUpdateUser(req.Session.UserID, user, func(us *ydtUserState) bool { return us.addFavorite(name) })
+				resp.Response.Text = "Добавила в избранное: " + name
 			} else {
-				resp.Response.Text = errorText
+				resp.UserState = ydtUpdateUser(req.Session.UserID, user, func(us *ydtUserState) bool { return us.removeFavorite(name) })
+				resp.Response.Text = "Убрала из избранного: " + name
 			}
+			resp.Response.Buttons = append(resp.Response.Buttons, YandexDialogsButton{Title: "Мои любимые"})
+		} else {
+			resp.Response.Text = "Сначала выберите, что послушать."
+			resp.Response.Buttons = append(resp.Response.Buttons, YandexDialogsButton{Title: "А что есть?"})
+		}
 
-		case ydtReactionNext:
-			state = yandexDialogsTalesReactionNext(resp.Response, req.Session.SkillID, state)
-
-		case ydtReactionPrevious:
-			state = yandexDialogsTalesReactionPrevious(resp.Response, req.Session.SkillID, state)
-
-		case ydtReactionRepeat:
-			state = yandexDialogsTalesReactionRepeat(resp.Response, req.Session.SkillID, state)
+	case ydtReactionFavorites:
+		if items := user.favorites(); len(items) > 0 {
+			state = yandexDialogsTalesReactionTitledList(resp.Response, req.Session.SkillID, "Ваши любимые:", items)
+		} else {
+			resp.Response.Text = "В избранном пока ничего нет. Скажите \"добавь в избранное\", когда что-то понравится."
+			resp.Response.Buttons = append(resp.Response.Buttons, YandexDialogsButton{Title: "А что есть?"})
+		}
 
-		case ydtReactionSelect:
-			if sel, ok := reactionData.(yandexDialogsTalesSelect); ok {
-				state = yandexDialogsTalesReactionSelect(resp.Response, req.Session.SkillID, sel.fileType, int(sel.index), sel.relative, state)
+	case ydtReactionHistory:
+		now := time.Now().In(ydtTimezone(req.Meta))
+		title, none := "Недавно мы слушали:", "Мы пока ничего не слушали."
+		from := time.Time{}
+		if dt, ok := reactionData.(YandexDialogsEntityDateTime); ok {
+			from = ydtDayStart(dt, now)
+			day := ydtDayName(from, now)
+			title = ydtCapitalize(day) + " мы слушали:"
+			if day == "сегодня" {
+				none = "Сегодня мы еще ничего не слушали."
 			} else {
-				resp.Response.Text = errorText
+				none = ydtCapitalize(day) + " мы ничего не слушали."
 			}
+		}
+		if items := user.recent(from); len(items) > 0 {
+			state = yandexDialogsTalesReactionTitledList(resp.Response, req.Session.SkillID, title, items)
+		} else {
+			resp.Response.Text = none
+			resp.Response.Buttons = append(resp.Response.Buttons, YandexDialogsButton{Title: "А что есть?"})
+		}
 
-		case ydtReactionRandom:
-			if fileType, ok := reactionData.(ydtFileType); ok {
-				state = yandexDialogsTalesReactionRandom(resp.Response, req.Session.SkillID, fileType)
+	case ydtReactionContinue, ydtReactionRestart:
+		if queue, ok := state.(yandexDialogsTalesQueue); ok {
+			if reaction == ydtReactionRestart {
+				queue.part = 0
 			} else {
-				resp.Response.Text = errorText
+				queue, _ = queue.advance(ydtPartsPerTurn)
 			}
-
-		default: // ydtReactionNone
-			if req.Session.New {
-				state = nil
-				resp.Response.Text = "Что бы вам рассказать?"
-				resp.Response.Buttons = append(resp.Response.Buttons, YandexDialogsButton{Title: "А что есть?"})
+			state = yandexDialogsTalesReactionPlayQueue(resp.Response, req.Session.SkillID, queue)
+		} else if part, ok := state.(yandexDialogsTalesPart); ok {
+			if reaction == ydtReactionRestart {
+				part.part = 0
 			} else {
-				state = yandexDialogsTalesReactionNotRecognized(resp.Response, req.Session.SkillID, state)
+				part.part += int32(ydtPartsPerTurn)
 			}
+			state = yandexDialogsTalesReactionPlay(resp.Response, req.Session.SkillID, part.yandexDialogsTalesItem, int(part.part), state)
+		} else if item, ok := state.(yandexDialogsTalesItem); ok && reaction == ydtReactionRestart {
+			state = yandexDialogsTalesReactionPlay(resp.Response, req.Session.SkillID, item, 0, state)
+		} else if item, part, ok := user.last(); ok {
+			if reaction == ydtReactionRestart {
+				part = 0
+			}
+			state = yandexDialogsTalesReactionPlay(resp.Response, req.Session.SkillID, item, int(part), state)
+		} else {
+			resp.Response.Text = "Мы пока ничего не слушали. Что бы вам рассказать?"
+			resp.Response.Buttons = append(resp.Response.Buttons, YandexDialogsButton{Title: "А что есть?"})
 		}
 
-		resp.SessionState = yandexDialogsTalesSetSession(state)
-	}
+	case ydtReactionQueue:
+		if q, ok := reactionData.(ydtQueueRequest); ok {
+			state = yandexDialogsTalesReactionQueue(resp.Response, req.Session.SkillID, q, state)
+		} else {
+			resp.Response.Text = errorText
+		}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	json.NewEncoder(w).Encode(resp)
-}
+	case ydtReactionPlaylists:
+		state = yandexDialogsTalesReactionPlaylists(resp.Response, req.Session.SkillID)
 
-func yandexDialogsTalesGetSession(state *YandexDialogsRequestState) interface{} {
-	if state != nil {
-		if s, ok := state.Session["value"]; ok {
-			if st, err := decodeState(s); err == nil {
-				return st
-			}
+	case ydtReactionYes:
+		_, isPart := state.(yandexDialogsTalesPart)
+		_, isQueue := state.(yandexDialogsTalesQueue)
+		if isPart || isQueue {
+			// "Продолжить?" - "да"
+			state = yandexDialogsTalesReactionNext(resp.Response, req.Session.SkillID, state)
+		} else {
+			state = yandexDialogsTalesReactionOverview(resp.Response, req.Session.SkillID, ydtTypeUnknown)
+		}
+
+	default: // ydtReactionNone
+		if req.Session.New {
+			state = nil
+			resp.Response.Text = "Что бы вам рассказать?"
+			resp.Response.Buttons = append(resp.Response.Buttons, YandexDialogsButton{Title: "А что есть?"})
+		} else {
+			state = yandexDialogsTalesReactionNotRecognized(resp.Response, req.Session.SkillID, state)
 		}
 	}
-	return nil
-}
 
-func yandexDialogsTalesSetSession(state interface{}) interface{} {
-	if state != nil {
-		if s, err := encodeState(state); err == nil {
-			return map[string]string{
-				"value": s,
+	// remember what was played and where to resume: the first part which is not played yet
+	if resp.Response.TTS != "" {
+		item, ok := state.(yandexDialogsTalesItem)
+		resume := int32(0)
+		if part, isPart := state.(yandexDialogsTalesPart); isPart {
+			item, resume, ok = part.yandexDialogsTalesItem, part.part+int32(ydtPartsPerTurn), true
+		} else if queue, isQueue := state.(yandexDialogsTalesQueue); isQueue {
+			if next, more := queue.advance(ydtPartsPerTurn); more && next.part > 0 {
+				item, resume, ok = next.items[next.current], next.part, true
+			} else if more && next.current > 0 {
+				item, ok = next.items[next.current-1], true
 			}
 		}
+		if name := ydtTaleName(item); ok && name != "" {
+			resp.UserState = ydtUpdateUser(req.Session.UserID, user, func(us *ydtUserState) bool { return us.played(name, resume) })
+		}
 	}
-	return nil
+
+	return state
 }
 
 func yandexDialogsTalesReactionNotRecognized(r *YandexDialogsResponse, skillID string, state interface{}) interface{} {
@@ -320,10 +417,7 @@ func yandexDialogsTalesReactionSlice(r *YandexDialogsResponse, skillID string, f
 				bt.WriteString(" ")
 				bt.WriteString(t)
 			} else {
-				t, g = "стишки", -1
-				if fileType != ydtTypeVerse {
-					t, g = yandexDialogsTalesFileTypeName(fileType, 2)
-				}
+				t, g = yandexDialogsTalesFileTypePlural(fileType)
 				bt.WriteString(t)
 				bt.WriteString(" с ")
 				bt.WriteString(yandexDialogsTalesSequence(index+1, g, 2))
@@ -358,9 +452,13 @@ func yandexDialogsTalesReactionSlice(r *YandexDialogsResponse, skillID string, f
 }
 
 func yandexDialogsTalesReactionList(r *YandexDialogsResponse, skillID string, list []yandexDialogsTalesItem) interface{} {
+	return yandexDialogsTalesReactionTitledList(r, skillID, "У меня есть:", list)
+}
+
+func yandexDialogsTalesReactionTitledList(r *YandexDialogsResponse, skillID string, title string, list []yandexDialogsTalesItem) interface{} {
 	var bt strings.Builder
 
-	bt.WriteString("У меня есть:")
+	bt.WriteString(title)
 	for i, item := range list {
 		if f, ok := ydtFileTypes[item.fileType]; ok && int(item.index) < len(f) {
 			t, g := yandexDialogsTalesFileTypeName(item.fileType, 1)
@@ -377,6 +475,15 @@ func yandexDialogsTalesReactionList(r *YandexDialogsResponse, skillID string, li
 }
 
 func yandexDialogsTalesReactionNext(r *YandexDialogsResponse, skillID string, state interface{}) interface{} {
+	if queue, ok := state.(yandexDialogsTalesQueue); ok {
+		if queue, ok = queue.advance(ydtPartsPerTurn); ok {
+			return yandexDialogsTalesReactionPlayQueue(r, skillID, queue)
+		}
+		return yandexDialogsTalesReactionNotRecognized(r, skillID, state)
+	}
+	if part, ok := state.(yandexDialogsTalesPart); ok {
+		return yandexDialogsTalesReactionPlay(r, skillID, part.yandexDialogsTalesItem, int(part.part)+ydtPartsPerTurn, state)
+	}
 	if slice, ok := state.(yandexDialogsTalesSlice); ok {
 		s := yandexDialogsTalesReactionSlice(r, skillID, slice.fileType, int(slice.index+slice.length), int(slice.length))
 		if s == nil {
@@ -388,6 +495,13 @@ func yandexDialogsTalesReactionNext(r *YandexDialogsResponse, skillID string, st
 }
 
 func yandexDialogsTalesReactionPrevious(r *YandexDialogsResponse, skillID string, state interface{}) interface{} {
+	if queue, ok := state.(yandexDialogsTalesQueue); ok {
+		queue, _ = queue.advance(-ydtPartsPerTurn)
+		return yandexDialogsTalesReactionPlayQueue(r, skillID, queue)
+	}
+	if part, ok := state.(yandexDialogsTalesPart); ok {
+		return yandexDialogsTalesReactionPlay(r, skillID, part.yandexDialogsTalesItem, max(0, int(part.part)-ydtPartsPerTurn), state)
+	}
 	if slice, ok := state.(yandexDialogsTalesSlice); ok {
 		s := yandexDialogsTalesReactionSlice(r, skillID, slice.fileType, int(slice.index-slice.length), int(slice.length))
 		if s == nil {
@@ -405,6 +519,10 @@ func yandexDialogsTalesReactionRepeat(r *YandexDialogsResponse, skillID string,
 		return yandexDialogsTalesReactionSlice(r, skillID, slice.fileType, int(slice.index), int(slice.length))
 	} else if item, ok := state.(yandexDialogsTalesItem); ok {
 		return yandexDialogsTalesReactionSelect(r, skillID, item.fileType, int(item.index), false, nil)
+	} else if part, ok := state.(yandexDialogsTalesPart); ok {
+		return yandexDialogsTalesReactionPlay(r, skillID, part.yandexDialogsTalesItem, int(part.part), state)
+	} else if queue, ok := state.(yandexDialogsTalesQueue); ok {
+		return yandexDialogsTalesReactionPlayQueue(r, skillID, queue)
 	}
 	return yandexDialogsTalesReactionOverview(r, skillID, ydtTypeUnknown)
 }
@@ -427,30 +545,57 @@ func yandexDialogsTalesReactionSelect(r *YandexDialogsResponse, skillID string,
 	}
 	if index >= 0 {
 		if f, ok := ydtFileTypes[fileType]; ok && index < len(f) {
-			var bt strings.Builder
-			var btts strings.Builder
+			return yandexDialogsTalesReactionPlay(r, skillID, yandexDialogsTalesItem{fileType, int32(index)}, 0, state)
+		}
+	}
 
-			t, _ := yandexDialogsTalesFileTypeName(fileType, 1)
-			bt.WriteString(t)
-			bt.WriteString(" ")
-			bt.WriteString(f[index].name)
+	r.Text = "Не нашла, попробуйте еще раз."
+	return state
+}
 
-			for _, id := range f[index].ids {
-				btts.WriteString(fmt.Sprintf("<speaker audio='dialogs-upload/%v/%v.opus'>", skillID, id))
-			}
-			btts.WriteString("Рассказать что-нибудь еще?")
+// yandexDialogsTalesReactionPlay plays up to ydtPartsPerTurn parts of the tale starting from the part
+func yandexDialogsTalesReactionPlay(r *YandexDialogsResponse, skillID string, item yandexDialogsTalesItem, part int, state interface{}) interface{} {
+	f, ok := ydtFileTypes[item.fileType]
+	if !ok || item.index < 0 || int(item.index) >= len(f) {
+		r.Text = "Не нашла, попробуйте еще раз."
+		return state
+	}
+	tale := f[item.index]
+	if part < 0 || part >= len(tale.ids) {
+		part = 0
+	}
+	end := min(part+ydtPartsPerTurn, len(tale.ids))
 
-			r.Text = bt.String()
-			r.TTS = btts.String()
-			r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "Хватит"})
-			r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "А что есть?"})
+	var bt strings.Builder
+	var btts strings.Builder
 
-			return yandexDialogsTalesItem{fileType, int32(index)}
-		}
+	t, _ := yandexDialogsTalesFileTypeName(item.fileType, 1)
+	bt.WriteString(t)
+	bt.WriteString(" ")
+	bt.WriteString(tale.name)
+	if len(tale.ids) > ydtPartsPerTurn {
+		bt.WriteString(fmt.Sprintf(", часть %v из %v", part/ydtPartsPerTurn+1, (len(tale.ids)+ydtPartsPerTurn-1)/ydtPartsPerTurn))
 	}
 
-	r.Text = "Не нашла, попробуйте еще раз."
-	return state
+	for _, id := range tale.ids[part:end] {
+		btts.WriteString(fmt.Sprintf("<speaker audio='dialogs-upload/%v/%v.opus'>", skillID, id))
+	}
+
+	r.Text = bt.String()
+	if end < len(tale.ids) {
+		btts.WriteString("Продолжить?")
+		r.TTS = btts.String()
+		r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "Продолжить"})
+		r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "С начала"})
+		r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "Хватит"})
+		return yandexDialogsTalesPart{item, int32(part)}
+	}
+
+	btts.WriteString("Рассказать что-нибудь еще?")
+	r.TTS = btts.String()
+	r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "Хватит"})
+	r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "А что есть?"})
+	return item
 }
 
 func yandexDialogsTalesReactionRandom(r *YandexDialogsResponse, skillID string, fileType ydtFileType) interface{} {
@@ -459,14 +604,15 @@ func yandexDialogsTalesReactionRandom(r *YandexDialogsResponse, skillID string,
 	}
 
 	if fileType == ydtTypeUnknown {
-		fileType = ydtFileType(ydtRand.Intn(int(ydtTypeJoke)) + 1)
+		last := ydtFileType(len(ydtCategories) - 1)
+		fileType = ydtFileType(ydtRand.Intn(int(last)) + 1)
 		o := fileType
 		for {
 			if _, ok := ydtFileTypes[fileType]; ok {
 				break
 			}
-			if fileType == ydtTypeJoke {
-				fileType = ydtTypeFairyTale
+			if fileType == last {
+				fileType = 1
 			} else {
 				fileType++
 			}
@@ -507,6 +653,7 @@ var ydtwmUntil = map[string]struct{}{
 
 var ydtwmPlay = map[string]struct{}{
 	"расскажи": {}, "рассказать": {}, "рассказывай": {},
+	"включи": {}, "включить": {}, "поставь": {},
 	"давай": {},
 	"спой":  {}, "спеть": {},
 }
@@ -522,16 +669,82 @@ var ydtwmOverview = map[string]struct{}{
 	"список": {}, "чем": {}, "чём": {}, "можешь": {},
 }
 
-var ydtwmFileType = map[string]ydtFileType{
-	"сказка": ydtTypeFairyTale, "сказки": ydtTypeFairyTale, "сказке": ydtTypeFairyTale, "сказкой": ydtTypeFairyTale, "сказку": ydtTypeFairyTale, "сказок": ydtTypeFairyTale, "сказками": ydtTypeFairyTale,
-	"история": ydtTypeStory, "истории": ydtTypeStory, "историей": ydtTypeStory, "историй": ydtTypeStory, "историю": ydtTypeStory,
-	"песня": ydtTypeSong, "песни": ydtTypeSong, "песне": ydtTypeSong, "песней": ydtTypeSong, "песен": ydtTypeSong, "песню": ydtTypeSong,
-	"стишок": ydtTypeVerse, "стишка": ydtTypeVerse, "стишку": ydtTypeVerse, "стишком": ydtTypeVerse, "стишков": ydtTypeVerse, "стишки": ydtTypeVerse,
-	"шутка": ydtTypeJoke, "шутки": ydtTypeJoke, "шутке": ydtTypeJoke, "шуткой": ydtTypeJoke, "шуток": ydtTypeJoke, "шутку": ydtTypeSong,
+var ydtwmFavorite = map[string]struct{}{
+	"избранное": {}, "избранного": {}, "избранные": {}, "избранном": {},
+	"любимые": {}, "любимое": {}, "любимых": {}, "любимую": {}, "любимый": {},
+}
+
+var ydtwmAdd = map[string]struct{}{
+	"добавь": {}, "добавить": {}, "запомни": {}, "сохрани": {},
+}
+
+var ydtwmRemove = map[string]struct{}{
+	"удали": {}, "удалить": {}, "убери": {}, "убрать": {},
+}
+
+var ydtwmHistory = map[string]struct{}{
+	"слушали": {}, "слушал": {}, "слушала": {}, "недавно": {}, "последние": {},
+}
+
+var ydtwmDay = map[string]YandexDialogsEntityDateTime{
+	"сегодня":   {Day: 0, DayIsRelative: true},
+	"вчера":     {Day: -1, DayIsRelative: true},
+	"позавчера": {Day: -2, DayIsRelative: true},
+}
+
+// ydtwmOrdinal maps the ordinal numerals to the numbers, "третью" is 3
+var ydtwmOrdinal = func() map[string]int {
+	stems := []struct {
+		stem    string
+		n       int
+		endings string
+	}{
+		{"перв", 1, "ый ая ую ое ого ой"},
+		{"втор", 2, "ой ая ую ое ого"},
+		{"трет", 3, "ий ья ью ье ьего ьей"},
+		{"четвер", 4, "тый тая тую тое того той"},
+		{"пят", 5, "ый ая ую ое ого ой"},
+		{"шест", 6, "ой ая ую ое ого"},
+		{"седьм", 7, "ой ая ую ое ого"},
+		{"восьм", 8, "ой ая ую ое ого"},
+		{"девят", 9, "ый ая ую ое ого ой"},
+		{"десят", 10, "ый ая ую ое ого ой"},
+	}
+	m := make(map[string]int)
+	for _, s := range stems {
+		for _, e := range strings.Fields(s.endings) {
+			m[s.stem+e] = s.n
+		}
+	}
+	return m
+}()
+
+var ydtwmYes = map[string]struct{}{
+	"да": {}, "давай": {}, "ага": {}, "угу": {},
+}
+
+var ydtwmContinue = map[string]struct{}{
+	"продолжи": {}, "продолжай": {}, "продолжить": {}, "снова": {}, "опять": {},
+}
+
+var ydtwmPlaylist = map[string]struct{}{
+	"плейлист": {}, "плейлиста": {}, "плейлисты": {}, "плейлистов": {},
+	"подборка": {}, "подборку": {}, "подборки": {}, "подборок": {},
+}
+
+// ydtwmDuration maps the unit words to seconds
+var ydtwmDuration = map[string]int{
+	"минута": 60, "минуту": 60, "минуты": 60, "минут": 60, "минутку": 60, "минуток": 60,
+	"час": 3600, "часа": 3600, "часов": 3600, "часик": 3600, "часика": 3600,
+	"полчаса": 1800,
+}
+
+var ydtwmRestart = map[string]struct{}{
+	"начала": {}, "сначала": {}, "заново": {},
 }
 
 func yandexDialogsTalesReaction(r YandexDialogsRequest) (ydtReaction, interface{}) {
-	if r.Nlu == nil && len(r.Nlu.Tokens) <= 0 {
+	if r.Nlu == nil || len(r.Nlu.Tokens) <= 0 {
 		return ydtReactionNone, nil
 	}
 
@@ -542,20 +755,53 @@ func yandexDialogsTalesReaction(r YandexDialogsRequest) (ydtReaction, interface{
 	var firstNumber int = 0
 	var secondNumber int = 0
 	var fileType ydtFileType = ydtTypeUnknown
+	var favoriteState, addState, removeState, historyState, continueState, restartState bool
+	var historyDay *YandexDialogsEntityDateTime
+	var playlistState, pluralState bool
+	var durationUnit int = 0
 
-	var tb strings.Builder
+	yes := true
 	for _, t := range r.Nlu.Tokens {
-		t = strings.ToLower(t)
+		if _, ok := ydtwmYes[strings.ToLower(t)]; !ok {
+			yes = false
+			break
+		}
+	}
+	if yes {
+		return ydtReactionYes, nil
+	}
+
+	var titleTokens []string
+	addNumber := func(n int) {
+		if firstNumber == 0 {
+			firstNumber = n
+		} else if secondNumber == 0 {
+			secondNumber = n
+		}
+	}
+
+	entities := ydEntitiesByToken(r.Nlu)
+	for i := 0; i < len(r.Nlu.Tokens); i++ {
+		if e, ok := entities[i]; ok {
+			if n, ok := e.number(); ok && n > 0 && n == float64(int(n)) {
+				addNumber(int(n))
+				i = e.Tokens.End - 1
+				continue
+			}
+			if dt, ok := e.dateTime(); ok && dt.hasDate() {
+				historyDay = &dt
+				i = e.Tokens.End - 1
+				continue
+			}
+		}
+
+		t := strings.ToLower(r.Nlu.Tokens[i])
 		if ft, ok := ydtwmFileType[t]; ok {
 			fileType = ft
+			pluralState = ydtCategories[ft].isPlural(t)
 			continue
 		}
 
-		if tb.Len() > 0 {
-			tb.WriteString(" ")
-		}
-		tb.WriteString(t)
-
 		if _, ok := ydtwmDone[t]; ok {
 			return ydtReactionDone, nil
 		}
@@ -569,7 +815,25 @@ func yandexDialogsTalesReaction(r YandexDialogsRequest) (ydtReaction, interface{
 			return ydtReactionRepeat, nil
 		}
 
-		if _, ok := ydtwmOverview[t]; ok {
+		if _, ok := ydtwmFavorite[t]; ok {
+			favoriteState = true
+		} else if _, ok := ydtwmAdd[t]; ok {
+			addState = true
+		} else if _, ok := ydtwmRemove[t]; ok {
+			removeState = true
+		} else if _, ok := ydtwmHistory[t]; ok {
+			historyState = true
+		} else if day, ok := ydtwmDay[t]; ok {
+			historyDay = &day
+		} else if _, ok := ydtwmContinue[t]; ok {
+			continueState = true
+		} else if _, ok := ydtwmRestart[t]; ok {
+			restartState = true
+		} else if _, ok := ydtwmPlaylist[t]; ok {
+			playlistState = true
+		} else if unit, ok := ydtwmDuration[t]; ok {
+			durationUnit = unit
+		} else if _, ok := ydtwmOverview[t]; ok {
 			overviewState = true
 		} else if _, ok := ydtwmRandom[t]; ok {
 			randomState = true
@@ -577,15 +841,58 @@ func yandexDialogsTalesReaction(r YandexDialogsRequest) (ydtReaction, interface{
 			untilState = true
 		} else if _, ok := ydtwmPlay[t]; ok {
 			playState = true
+		} else if n, ok := ydtwmOrdinal[t]; ok {
+			addNumber(n)
 		} else if n, err := strconv.Atoi(t); err == nil && n > 0 {
-			if firstNumber == 0 {
-				firstNumber = n
-			} else if secondNumber == 0 {
-				secondNumber = n
-			}
+			addNumber(n)
+		} else {
+			titleTokens = append(titleTokens, t)
 		}
 	}
 
+	if favoriteState {
+		if addState {
+			return ydtReactionFavoriteAdd, nil
+		} else if removeState {
+			return ydtReactionFavoriteRemove, nil
+		}
+		return ydtReactionFavorites, nil
+	}
+	if (historyState || continueState) && len(titleTokens) > 0 && len(ydtFuzzyMatch(titleTokens, fileType)) > 0 {
+		// "расскажи колобок снова" asks for the title, not to continue
+		historyState, continueState = false, false
+	}
+	if historyState || historyDay != nil {
+		if historyDay != nil {
+			return ydtReactionHistory, *historyDay
+		}
+		return ydtReactionHistory, nil
+	}
+	if restartState {
+		return ydtReactionRestart, nil
+	}
+	if continueState {
+		return ydtReactionContinue, nil
+	}
+	if durationUnit > 0 || playlistState || (pluralState && firstNumber > 1 && secondNumber == 0 && !untilState) {
+		// bedtime queue: "расскажи 3 сказки", "включи колыбельные на 20 минут", "включи подборку на ночь"
+		q := ydtQueueRequest{fileType: fileType, playlist: -1}
+		if durationUnit > 0 {
+			q.seconds = max(firstNumber, 1) * durationUnit
+			if secondNumber > 0 {
+				q.count = firstNumber
+				q.seconds = secondNumber * durationUnit
+			}
+		} else if !playlistState || firstNumber > 0 {
+			q.count = firstNumber
+		}
+		if playlistState || fileType == ydtTypeUnknown {
+			if q.playlist = ydtMatchPlaylist(titleTokens); q.playlist < 0 && playlistState {
+				return ydtReactionPlaylists, nil
+			}
+		}
+		return ydtReactionQueue, q
+	}
 	if randomState {
 		return ydtReactionRandom, fileType
 	}
@@ -603,101 +910,25 @@ func yandexDialogsTalesReaction(r YandexDialogsRequest) (ydtReaction, interface{
 	if firstNumber > 0 {
 		return ydtReactionSelect, yandexDialogsTalesSelect{yandexDialogsTalesItem{fileType, int32(firstNumber - 1)}, true}
 	}
-	if playState {
-		return ydtReactionSelect, yandexDialogsTalesSelect{yandexDialogsTalesItem{fileType, 0}, true}
-	}
 	if overviewState || (len(r.Nlu.Tokens) == 1 && fileType != ydtTypeUnknown) {
 		return ydtReactionOverview, fileType
 	}
 
-	tokens := tb.String()
-	found := []yandexDialogsTalesItem{}
-	bestMk := 0
-	for ft, fs := range ydtFileTypes {
-		if fileType != ydtTypeUnknown && fileType != ft {
-			continue
-		}
-		for i, f := range fs {
-			mk := 0
-			for _, k := range f.keys {
-				if strings.Contains(tokens, k) {
-					mk++
-				}
-				if tokens == k {
-					mk++
-				}
-			}
-			if mk > 0 {
-				if mk > bestMk {
-					found = nil
-					bestMk = mk
-				} else if mk < bestMk {
-					continue
-				}
-				found = append(found, yandexDialogsTalesItem{ft, int32(i)})
-			}
-		}
-	}
+	found := ydtFuzzyMatch(titleTokens, fileType)
 	l := len(found)
 	if l == 1 {
 		return ydtReactionSelect, yandexDialogsTalesSelect{found[0], false}
 	} else if l > 1 {
 		return ydtReactionList, found
 	}
-
-	return ydtReactionNone, nil
-}
-
-func yandexDialogsTalesFileTypeName(fileType ydtFileType, count int) (text string, kind int) {
-	var m int = 0
-	if !(count > 10 && count < 15) {
-		m = count % 10
+	if playlist := ydtMatchPlaylist(titleTokens); playlist >= 0 {
+		return ydtReactionQueue, ydtQueueRequest{fileType: fileType, playlist: playlist}
 	}
-	kind = 1
-	switch fileType {
-	case ydtTypeFairyTale:
-		if m == 1 {
-			text = "сказка"
-		} else if m > 1 && m < 5 {
-			text = "сказки"
-		} else {
-			text = "сказок"
-		}
-	case ydtTypeStory:
-		if m == 1 {
-			text = "история"
-		} else if m > 1 && m < 5 {
-			text = "ист+ории"
-		} else {
-			text = "историй"
-		}
-	case ydtTypeSong:
-		if m == 1 {
-			text = "песня"
-		} else if m > 1 && m < 5 {
-			text = "песни"
-		} else {
-			text = "песен"
-		}
-	case ydtTypeVerse:
-		kind = -1
-		if m == 1 {
-			text = "стишок"
-		} else if m > 1 && m < 5 {
-			text = "стишка"
-		} else {
-			text = "стишков"
-		}
-	case ydtTypeJoke:
-		if m == 1 {
-			text = "шутка"
-		} else if m > 1 && m < 5 {
-			text = "шутки"
-		} else {
-			text = "шуток"
-		}
+	if playState {
+		return ydtReactionSelect, yandexDialogsTalesSelect{yandexDialogsTalesItem{fileType, 0}, true}
 	}
-	return
+
+	return ydtReactionNone, nil
 }
 
 func yandexDialogsTalesNumber(n, r int) string {
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// yandexDialogsTalesCategory declares the type of the tales: the word forms used in the responses and the trigger words
+type yandexDialogsTalesCategory struct {
+	Type   string   `yaml:"type"`
+	One    string   `yaml:"one"`              // one item, "сказка"
+	Few    string   `yaml:"few"`              // 2-4 items, "сказки"
+	Many   string   `yaml:"many"`             // 5 and more items, also used as genitive plural, "сказок"
+	Plural string   `yaml:"plural,omitempty"` // nominative plural, default is the few form
+	Gender string   `yaml:"gender,omitempty"` // feminine (default), masculine or neuter
+	Words  []string `yaml:"words,omitempty"`  // trigger words in addition to the forms above
+}
+
+// yandexDialogsTalesCatalog is the content of yandexDialogs.tales file, the plain list of tales is accepted too
+type yandexDialogsTalesCatalog struct {
+	Categories []yandexDialogsTalesCategory `yaml:"categories,omitempty"`
+	Playlists  []yandexDialogsTalesPlaylist `yaml:"playlists,omitempty"`
+	Tales      []yandexDialogsTale          `yaml:"tales"`
+}
+
+// UnmarshalYAML accepts both the list of tales and the catalog with categories
+func (c *yandexDialogsTalesCatalog) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&c.Tales); err == nil {
+		return nil
+	}
+	type catalog yandexDialogsTalesCatalog
+	return unmarshal((*catalog)(c))
+}
+
+// MarshalYAML writes the plain list of tales when no categories and playlists declared
+func (c yandexDialogsTalesCatalog) MarshalYAML() (interface{}, error) {
+	if len(c.Categories) <= 0 && len(c.Playlists) <= 0 {
+		return c.Tales, nil
+	}
+	type catalog yandexDialogsTalesCatalog
+	return catalog(c), nil
+}
+
+type ydtCategory struct {
+	name   string
+	one    string
+	few    string
+	many   string
+	plural string
+	kind   int // 1 - feminine, -1 - masculine, 0 - neuter
+}
+
+var ydtDefaultCategories = []yandexDialogsTalesCategory{
+	{
+		Type: "fairytale", One: "сказка", Few: "сказки", Many: "сказок",
+		Words: []string{"сказке", "сказкой", "сказку", "сказками"},
+	},
+	{
+		Type: "story", One: "история", Few: "ист+ории", Many: "историй",
+		Words: []string{"историей", "историю"},
+	},
+	{
+		Type: "song", One: "песня", Few: "песни", Many: "песен",
+		Words: []string{"песне", "песней", "песню"},
+	},
+	{
+		Type: "verse", One: "стишок", Few: "стишка", Many: "стишков", Plural: "стишки", Gender: "masculine",
+		Words: []string{"стишку", "стишком"},
+	},
+	{
+		Type: "joke", One: "шутка", Few: "шутки", Many: "шуток",
+		Words: []string{"шутке", "шуткой", "шутку"},
+	},
+}
+
+// ydtCategories is indexed by ydtFileType, the first entry is ydtTypeUnknown
+var ydtCategories []ydtCategory
+var ydtCategoryTypes map[string]ydtFileType
+var ydtwmFileType map[string]ydtFileType
+
+func validateYandexDialogsTalesCategories(categories []yandexDialogsTalesCategory, cfgError configError) {
+	ydtCategories = []ydtCategory{{}}
+	ydtCategoryTypes = make(map[string]ydtFileType)
+	ydtwmFileType = make(map[string]ydtFileType)
+
+	words := make(map[ydtFileType][]string)
+	add := func(category yandexDialogsTalesCategory, categoryError func(msg string)) {
+		c := ydtCategory{
+			name:   strings.ToLower(category.Type),
+			one:    category.One,
+			few:    category.Few,
+			many:   category.Many,
+			plural: category.Plural,
+			kind:   1,
+		}
+		if c.name == "" {
+			categoryError("type is required.")
+			return
+		}
+		if c.one == "" || c.few == "" || c.many == "" {
+			categoryError("one, few and many forms are required.")
+			return
+		}
+		if c.plural == "" {
+			c.plural = c.few
+		}
+		switch strings.ToLower(category.Gender) {
+		case "", "feminine":
+		case "masculine":
+			c.kind = -1
+		case "neuter":
+			c.kind = 0
+		default:
+			categoryError(fmt.Sprintf("unknown gender '%v'.", category.Gender))
+			return
+		}
+
+		fileType, ok := ydtCategoryTypes[c.name]
+		if ok {
+			ydtCategories[fileType] = c
+		} else {
+			if len(ydtCategories) > 255 {
+				categoryError("too many categories.")
+				return
+			}
+			fileType = ydtFileType(len(ydtCategories))
+			ydtCategoryTypes[c.name] = fileType
+			ydtCategories = append(ydtCategories, c)
+		}
+		w := append([]string{c.one, c.few, c.many, c.plural}, category.Words...)
+		for i := range w {
+			w[i] = strings.ToLower(strings.ReplaceAll(w[i], "+", ""))
+		}
+		words[fileType] = w
+	}
+
+	for _, category := range ydtDefaultCategories {
+		add(category, func(msg string) {})
+	}
+	for i, category := range categories {
+		add(category, func(msg string) {
+			cfgError(fmt.Sprintf("yandexDialogs.tales, category %v: %v", i, msg))
+		})
+	}
+
+	for fileType := ydtFileType(1); int(fileType) < len(ydtCategories); fileType++ {
+		for _, w := range words[fileType] {
+			if ft, ok := ydtwmFileType[w]; ok && ft != fileType {
+				cfgError(fmt.Sprintf("yandexDialogs.tales: word '%v' is used by categories '%v' and '%v'.", w, ydtCategories[ft].name, ydtCategories[fileType].name))
+				continue
+			}
+			ydtwmFileType[w] = fileType
+		}
+	}
+}
+
+func parseYandexDialogsTaleType(t string) (ydtFileType, error) {
+	if fileType, ok := ydtCategoryTypes[strings.ToLower(t)]; ok {
+		return fileType, nil
+	}
+	return 0, fmt.Errorf("unrecognized tale type")
+}
+
+func yandexDialogsTalesFileTypeName(fileType ydtFileType, count int) (text string, kind int) {
+	if fileType == ydtTypeUnknown || int(fileType) >= len(ydtCategories) {
+		return "", 1
+	}
+	c := ydtCategories[fileType]
+	var m int = 0
+	if !(count > 10 && count < 15) {
+		m = count % 10
+	}
+	if m == 1 {
+		text = c.one
+	} else if m > 1 && m < 5 {
+		text = c.few
+	} else {
+		text = c.many
+	}
+	return text, c.kind
+}
+
+// yandexDialogsTalesFileTypePlural returns nominative plural of the category
+func yandexDialogsTalesFileTypePlural(fileType ydtFileType) (text string, kind int) {
+	if fileType == ydtTypeUnknown || int(fileType) >= len(ydtCategories) {
+		return "", 1
+	}
+	return ydtCategories[fileType].plural, ydtCategories[fileType].kind
+}
+
+// isPlural tells if the word is the plural form of the category, i.e. "сказки" or "сказок" but not "сказка"
+func (c ydtCategory) isPlural(word string) bool {
+	for _, form := range []string{c.few, c.many, c.plural} {
+		if word == strings.ToLower(strings.ReplaceAll(form, "+", "")) && word != strings.ToLower(c.one) {
+			return true
+		}
+	}
+	return false
+}
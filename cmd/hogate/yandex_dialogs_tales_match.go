@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hbollon/go-edlib"
+)
+
+const ydtDefaultMatchThreshold = 0.8
+
+// the difference in similarity which makes the best match unambiguous
+const ydtMatchMargin = 0.1
+
+var ydtMatchThreshold = ydtDefaultMatchThreshold
+
+// common Russian endings, longest first
+var ydtStemEndings = []string{
+	"ами", "ями", "ого", "его", "ому", "ему", "ыми", "ими", "ой", "ей",
+	"ий", "ый", "ая", "яя", "ое", "ее", "ые", "ие", "ых", "их", "ую", "юю",
+	"ом", "ем", "ам", "ям", "ах", "ях", "ов", "ев",
+	"а", "я", "о", "е", "ы", "и", "у", "ю", "ь", "й",
+}
+
+const ydtMinStemLength = 3
+
+type ydtMatch struct {
+	item       yandexDialogsTalesItem
+	keys       int     // number of matched keys
+	similarity float64 // average similarity of matched keys
+}
+
+func validateYandexDialogsTalesMatchConfig(cfgError configError) {
+	ydtMatchThreshold = ydtDefaultMatchThreshold
+	if config.YandexDialogs == nil || config.YandexDialogs.MatchThreshold == 0 {
+		return
+	}
+	if t := config.YandexDialogs.MatchThreshold; t < 0 || t > 1 {
+		cfgError(fmt.Sprintf("yandexDialogs.matchThreshold: value %v is out of range 0..1.", t))
+	} else {
+		ydtMatchThreshold = t
+	}
+}
+
+// ydtStem strips the common ending of the word keeping at least 3 letters of the stem
+func ydtStem(word string) string {
+	word = strings.ReplaceAll(strings.ToLower(word), "ё", "е")
+	for _, ending := range ydtStemEndings {
+		if strings.HasSuffix(word, ending) && utf8.RuneCountInString(word)-utf8.RuneCountInString(ending) >= ydtMinStemLength {
+			return word[:len(word)-len(ending)]
+		}
+	}
+	return word
+}
+
+func ydtStems(words []string) []string {
+	stems := make([]string, 0, len(words))
+	for _, w := range words {
+		for _, f := range strings.Fields(w) {
+			stems = append(stems, ydtStem(f))
+		}
+	}
+	return stems
+}
+
+// ydtSimilarity returns the similarity of the stems in range 0..1, short stems must be equal
+func ydtSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	la, lb := utf8.RuneCountInString(a), utf8.RuneCountInString(b)
+	if la <= ydtMinStemLength || lb <= ydtMinStemLength {
+		return 0
+	}
+	l := max(la, lb)
+	levenshtein := 1 - float64(edlib.LevenshteinDistance(a, b))/float64(l)
+	jaroWinkler := float64(edlib.JaroWinklerSimilarity(a, b))
+	return max(levenshtein, jaroWinkler)
+}
+
+// ydtFuzzyMatch ranks the tales by the keys similar to the tokens; it returns the single tale if
+// it's the clear winner, otherwise all the tales with the best number of matched keys
+func ydtFuzzyMatch(tokens []string, fileType ydtFileType) []yandexDialogsTalesItem {
+	stems := ydtStems(tokens)
+	if len(stems) == 0 {
+		return nil
+	}
+
+	var matches []ydtMatch
+	for ft, fs := range ydtFileTypes {
+		if fileType != ydtTypeUnknown && fileType != ft {
+			continue
+		}
+		for i, f := range fs {
+			m := ydtMatch{item: yandexDialogsTalesItem{ft, int32(i)}}
+			for _, key := range f.stems {
+				best := 0.0
+				for _, s := range stems {
+					if sim := ydtSimilarity(key, s); sim > best {
+						best = sim
+					}
+				}
+				if best >= ydtMatchThreshold {
+					m.keys++
+					m.similarity += best
+				}
+			}
+			if m.keys > 0 {
+				m.similarity /= float64(m.keys)
+				matches = append(matches, m)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.keys != b.keys {
+			return a.keys > b.keys
+		}
+		if a.similarity != b.similarity {
+			return a.similarity > b.similarity
+		}
+		if a.item.fileType != b.item.fileType {
+			return a.item.fileType < b.item.fileType
+		}
+		return a.item.index < b.item.index
+	})
+
+	found := []yandexDialogsTalesItem{matches[0].item}
+	for _, m := range matches[1:] {
+		if m.keys < matches[0].keys {
+			break
+		}
+		found = append(found, m.item)
+	}
+	if len(found) > 1 && matches[0].similarity-matches[1].similarity >= ydtMatchMargin {
+		return found[:1]
+	}
+	return found
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// ydtLoadTestTales loads testdata/tales.yaml as the tales of the skill
+func ydtLoadTestTales(t *testing.T) {
+	t.Helper()
+	config = Config{YandexDialogs: &YandexDialogs{Tales: "testdata/tales.yaml"}}
+	configPath = ""
+	var errs []string
+	cfgError := func(msg string) { errs = append(errs, msg) }
+	validateYandexDialogsTalesConfig(cfgError)
+	validateYandexDialogsTalesMatchConfig(cfgError)
+	if len(errs) > 0 {
+		t.Fatalf("unable to load the tales: %v", errs)
+	}
+}
+
+func ydtTestType(t *testing.T, name string) ydtFileType {
+	t.Helper()
+	ft, err := parseYandexDialogsTaleType(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ft
+}
+
+func TestYDTStem(t *testing.T) {
+	tests := []struct {
+		word, stem string
+	}{
+		{"колобок", "колобок"},
+		{"колобка", "колобк"},
+		{"репку", "репк"},
+		{"сказками", "сказк"},
+		{"Ёлочка", "елочк"},
+		{"кот", "кот"},
+		{"три", "три"},
+	}
+	for _, test := range tests {
+		if stem := ydtStem(test.word); stem != test.stem {
+			t.Errorf("ydtStem(%q) = %q, want %q", test.word, stem, test.stem)
+		}
+	}
+}
+
+func TestYDTFuzzyMatch(t *testing.T) {
+	ydtLoadTestTales(t)
+	fairytale, song := ydtTestType(t, "fairytale"), ydtTestType(t, "song")
+	item := func(ft ydtFileType, index int32) yandexDialogsTalesItem {
+		return yandexDialogsTalesItem{ft, index}
+	}
+
+	tests := []struct {
+		utterance []string
+		fileType  ydtFileType
+		found     []yandexDialogsTalesItem
+	}{
+		// exact and inflected titles
+		{[]string{"колобок"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(fairytale, 0)}},
+		{[]string{"про", "колобка"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(fairytale, 0)}},
+		{[]string{"про", "репку"}, fairytale, []yandexDialogsTalesItem{item(fairytale, 1)}},
+		{[]string{"о", "теремке"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(fairytale, 2)}},
+		{[]string{"курочку", "рябу"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(fairytale, 3)}},
+		{[]string{"гусей", "лебедей"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(fairytale, 6)}},
+		// misspelled
+		{[]string{"колабок"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(fairytale, 0)}},
+		{[]string{"тиремок"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(fairytale, 2)}},
+		// more matched keys win, the same number of keys is ambiguous
+		{[]string{"машу", "и", "медведя"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(fairytale, 4)}},
+		{[]string{"про", "медведя"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(fairytale, 4), item(fairytale, 5)}},
+		// the type filter
+		{[]string{"антошку"}, ydtTypeUnknown, []yandexDialogsTalesItem{item(song, 0)}},
+		{[]string{"антошку"}, fairytale, nil},
+		// below the threshold
+		{[]string{"дракон"}, ydtTypeUnknown, nil},
+		{[]string{"самолет"}, ydtTypeUnknown, nil},
+		{[]string{"кол"}, ydtTypeUnknown, nil},
+		{nil, ydtTypeUnknown, nil},
+	}
+	for _, test := range tests {
+		if found := ydtFuzzyMatch(test.utterance, test.fileType); !reflect.DeepEqual(found, test.found) {
+			t.Errorf("ydtFuzzyMatch(%q, %v) = %v, want %v", test.utterance, test.fileType, found, test.found)
+		}
+	}
+}
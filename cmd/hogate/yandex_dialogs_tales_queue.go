@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// yandexDialogsTalesPlaylist is the predefined queue declared in the tales file
+type yandexDialogsTalesPlaylist struct {
+	Name    string   `yaml:"name"`
+	Keys    []string `yaml:"keys,omitempty"`
+	Tales   []string `yaml:"tales"`
+	Shuffle bool     `yaml:"shuffle,omitempty"`
+}
+
+type ydtPlaylist struct {
+	name    string
+	stems   []string
+	items   []yandexDialogsTalesItem
+	shuffle bool
+}
+
+var ydtPlaylists []ydtPlaylist
+
+// ydtQueueRequest is the bedtime request: the category or the playlist, the number of items and the total length
+type ydtQueueRequest struct {
+	fileType ydtFileType
+	playlist int
+	count    int
+	seconds  int
+}
+
+// yandexDialogsTalesQueue is the queue of tales played one after another, current and part point to the first part played during the last turn
+type yandexDialogsTalesQueue struct {
+	items   []yandexDialogsTalesItem
+	current int32
+	part    int32
+}
+
+func (s *yandexDialogsTalesQueue) write(w io.Writer) error {
+	l := len(s.items)
+	if l > math.MaxUint16 {
+		return fmt.Errorf("number of items %d is greater than %d", l, math.MaxUint16)
+	}
+	err := binary.Write(w, binary.LittleEndian, s.current)
+	if err == nil {
+		err = binary.Write(w, binary.LittleEndian, s.part)
+	}
+	if err == nil {
+		err = binary.Write(w, binary.LittleEndian, uint16(l))
+	}
+	for i := 0; err == nil && i < l; i++ {
+		err = s.items[i].write(w)
+	}
+	return err
+}
+
+func (s *yandexDialogsTalesQueue) read(r io.Reader) error {
+	var l uint16
+	err := binary.Read(r, binary.LittleEndian, &s.current)
+	if err == nil {
+		err = binary.Read(r, binary.LittleEndian, &s.part)
+	}
+	if err == nil {
+		err = binary.Read(r, binary.LittleEndian, &l)
+	}
+	if err == nil {
+		s.items = make([]yandexDialogsTalesItem, l)
+		for i := 0; err == nil && i < int(l); i++ {
+			err = s.items[i].read(r)
+		}
+	}
+	return err
+}
+
+func validateYandexDialogsTalesPlaylists(playlists []yandexDialogsTalesPlaylist, cfgError configError) {
+	ydtPlaylists = nil
+	for i, playlist := range playlists {
+		playlistError := func(msg string) {
+			cfgError(fmt.Sprintf("yandexDialogs.tales, playlist %v: %v", i, msg))
+		}
+		if playlist.Name == "" {
+			playlistError("name is required.")
+			continue
+		}
+		p := ydtPlaylist{name: playlist.Name, shuffle: playlist.Shuffle}
+		keys := playlist.Keys
+		if len(keys) <= 0 {
+			keys = strings.Fields(strings.ToLower(playlist.Name))
+		}
+		p.stems = ydtStems(keys)
+		for _, name := range playlist.Tales {
+			if item, ok := ydtFindTale(name); ok {
+				p.items = append(p.items, item)
+			} else {
+				playlistError(fmt.Sprintf("unknown tale '%v'.", name))
+			}
+		}
+		if len(p.items) <= 0 {
+			playlistError("no tales.")
+			continue
+		}
+		ydtPlaylists = append(ydtPlaylists, p)
+	}
+}
+
+// ydtMatchPlaylist returns the index of the playlist with the most keys similar to the tokens or -1
+func ydtMatchPlaylist(tokens []string) int {
+	stems := ydtStems(tokens)
+	found, foundKeys := -1, 0
+	for i, p := range ydtPlaylists {
+		keys := 0
+		for _, key := range p.stems {
+			for _, s := range stems {
+				if ydtSimilarity(key, s) >= ydtMatchThreshold {
+					keys++
+					break
+				}
+			}
+		}
+		if keys > foundKeys {
+			found, foundKeys = i, keys
+		} else if keys > 0 && keys == foundKeys {
+			found = -1 // ambiguous
+		}
+	}
+	return found
+}
+
+func ydtTaleLength(item yandexDialogsTalesItem) int {
+	if f, ok := ydtFileTypes[item.fileType]; ok && item.index >= 0 && int(item.index) < len(f) {
+		return int(f[item.index].length)
+	}
+	return 0
+}
+
+func ydtTaleParts(item yandexDialogsTalesItem) []string {
+	if f, ok := ydtFileTypes[item.fileType]; ok && item.index >= 0 && int(item.index) < len(f) {
+		return f[item.index].ids
+	}
+	return nil
+}
+
+// ydtBuildQueue picks the items of the playlist or random items of the category which fit the requested number and length
+func ydtBuildQueue(q ydtQueueRequest) []yandexDialogsTalesItem {
+	var candidates []yandexDialogsTalesItem
+	shuffle := true
+	if q.playlist >= 0 && q.playlist < len(ydtPlaylists) {
+		p := ydtPlaylists[q.playlist]
+		candidates = append(candidates, p.items...)
+		shuffle = p.shuffle
+	} else {
+		types := make([]ydtFileType, 0, len(ydtFileTypes))
+		for ft := range ydtFileTypes {
+			if q.fileType == ydtTypeUnknown || q.fileType == ft {
+				types = append(types, ft)
+			}
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+		for _, ft := range types {
+			for i := range ydtFileTypes[ft] {
+				candidates = append(candidates, yandexDialogsTalesItem{ft, int32(i)})
+			}
+		}
+	}
+	if shuffle {
+		ydtRand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	}
+
+	var items []yandexDialogsTalesItem
+	total := 0
+	for _, item := range candidates {
+		if q.count > 0 && len(items) >= q.count {
+			break
+		}
+		length := ydtTaleLength(item)
+		if q.seconds > 0 && total+length > q.seconds {
+			continue
+		}
+		total += length
+		items = append(items, item)
+	}
+	return items
+}
+
+func yandexDialogsTalesReactionQueue(r *YandexDialogsResponse, skillID string, q ydtQueueRequest, state interface{}) interface{} {
+	items := ydtBuildQueue(q)
+	if len(items) <= 0 {
+		if q.seconds > 0 {
+			r.Text = "Не нашла ничего такой длины, попробуйте еще раз."
+		} else {
+			r.Text = "Не нашла, попробуйте еще раз."
+		}
+		r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "А что есть?"})
+		return state
+	}
+	return yandexDialogsTalesReactionPlayQueue(r, skillID, yandexDialogsTalesQueue{items: items})
+}
+
+// advance moves the queue position by n parts forward (or backward if n is negative), it returns false when moved past the end
+func (s yandexDialogsTalesQueue) advance(n int) (yandexDialogsTalesQueue, bool) {
+	current, part := int(s.current), int(s.part)+n
+	for part < 0 && current > 0 {
+		current--
+		part += len(ydtTaleParts(s.items[current]))
+	}
+	if part < 0 {
+		part = 0
+	}
+	for current < len(s.items) && part >= len(ydtTaleParts(s.items[current])) {
+		part -= len(ydtTaleParts(s.items[current]))
+		current++
+	}
+	if current >= len(s.items) {
+		return s, false
+	}
+	return yandexDialogsTalesQueue{s.items, int32(current), int32(part)}, true
+}
+
+// yandexDialogsTalesReactionPlayQueue plays up to ydtPartsPerTurn parts of the queue quietly and ends the session after the last one
+func yandexDialogsTalesReactionPlayQueue(r *YandexDialogsResponse, skillID string, s yandexDialogsTalesQueue) interface{} {
+	if s.current < 0 || int(s.current) >= len(s.items) {
+		s.current, s.part = 0, 0
+	}
+	s, _ = s.advance(0)
+
+	var bt strings.Builder
+	var btts strings.Builder
+
+	current, part := int(s.current), int(s.part)
+	last := s.items[current]
+	for n := 0; n < ydtPartsPerTurn && current < len(s.items); {
+		ids := ydtTaleParts(s.items[current])
+		if part == 0 || n == 0 {
+			if bt.Len() > 0 {
+				bt.WriteString(", ")
+			}
+			t, _ := yandexDialogsTalesFileTypeName(s.items[current].fileType, 1)
+			bt.WriteString(t)
+			bt.WriteString(" ")
+			bt.WriteString(ydtTaleName(s.items[current]))
+		}
+		for ; n < ydtPartsPerTurn && part < len(ids); n, part = n+1, part+1 {
+			btts.WriteString(fmt.Sprintf("<speaker audio='dialogs-upload/%v/%v.opus'>", skillID, ids[part]))
+		}
+		last = s.items[current]
+		if part >= len(ids) {
+			current, part = current+1, 0
+		}
+	}
+
+	r.Text = bt.String()
+	r.TTS = btts.String()
+	if current >= len(s.items) {
+		r.Text += ". Спокойной ночи."
+		r.EndSession = true
+		return last
+	}
+	r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "Дальше"})
+	r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "Хватит"})
+	return s
+}
+
+func yandexDialogsTalesReactionPlaylists(r *YandexDialogsResponse, skillID string) interface{} {
+	if len(ydtPlaylists) <= 0 {
+		r.Text = "Подборок пока нет."
+		r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "А что есть?"})
+		return nil
+	}
+	var bt strings.Builder
+	bt.WriteString("Есть подборки:")
+	for _, p := range ydtPlaylists {
+		bt.WriteString("\n")
+		bt.WriteString(p.name)
+		r.Buttons = append(r.Buttons, YandexDialogsButton{Title: "Включи подборку " + p.name})
+	}
+	r.Text = bt.String()
+	return nil
+}
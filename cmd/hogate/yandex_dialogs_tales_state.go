@@ -11,14 +11,8 @@ import (
 
 type ydtFileType byte
 
-const (
-	ydtTypeUnknown = ydtFileType(iota)
-	ydtTypeFairyTale
-	ydtTypeStory
-	ydtTypeSong
-	ydtTypeVerse
-	ydtTypeJoke
-)
+// ydtTypeUnknown is no type, other types are the indexes of ydtCategories
+const ydtTypeUnknown = ydtFileType(0)
 
 type ydtStateType byte
 
@@ -28,6 +22,8 @@ const (
 	ydtStateItemArray
 	ydtStateSlice
 	ydtStateSelect
+	ydtStatePart
+	ydtStateQueue
 )
 
 type yandexDialogsTalesItem struct {
@@ -93,6 +89,28 @@ func (s *yandexDialogsTalesSelect) read(r io.Reader) error {
 	return err
 }
 
+// yandexDialogsTalesPart is the tale played partially, part is the first part played during the last turn
+type yandexDialogsTalesPart struct {
+	yandexDialogsTalesItem
+	part int32
+}
+
+func (s *yandexDialogsTalesPart) write(w io.Writer) error {
+	err := s.yandexDialogsTalesItem.write(w)
+	if err == nil {
+		err = binary.Write(w, binary.LittleEndian, s.part)
+	}
+	return err
+}
+
+func (s *yandexDialogsTalesPart) read(r io.Reader) error {
+	err := s.yandexDialogsTalesItem.read(r)
+	if err == nil {
+		err = binary.Read(r, binary.LittleEndian, &s.part)
+	}
+	return err
+}
+
 func serializeState(w io.Writer, s interface{}) (err error) {
 	switch s := s.(type) {
 	case yandexDialogsTalesItem:
@@ -125,6 +143,16 @@ func serializeState(w io.Writer, s interface{}) (err error) {
 			si := s
 			err = si.write(w)
 		}
+	case yandexDialogsTalesPart:
+		if err = binary.Write(w, binary.LittleEndian, ydtStatePart); err == nil {
+			si := s
+			err = si.write(w)
+		}
+	case yandexDialogsTalesQueue:
+		if err = binary.Write(w, binary.LittleEndian, ydtStateQueue); err == nil {
+			si := s
+			err = si.write(w)
+		}
 	default:
 		binary.Write(w, binary.LittleEndian, ydtStateUnknown)
 	}
@@ -166,6 +194,16 @@ func deserializeState(r io.Reader) (s interface{}, err error) {
 			if err = si.read(r); err == nil {
 				return si, nil
 			}
+		case ydtStatePart:
+			var si yandexDialogsTalesPart
+			if err = si.read(r); err == nil {
+				return si, nil
+			}
+		case ydtStateQueue:
+			var si yandexDialogsTalesQueue
+			if err = si.read(r); err == nil {
+				return si, nil
+			}
 		}
 	}
 	return nil, err
@@ -189,7 +227,7 @@ func decodeState(s string) (interface{}, error) {
 	if s == "" {
 		return nil, nil
 	}
-	b := make([]byte, len(s))
+	b := make([]byte, 4*len(s)) // "z" is decoded to 4 bytes
 	n, _, err := ascii85.Decode(b, []byte(s), true)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const ydsDefaultURL = "https://dialogs.yandex.net/api/v1"
+
+type ydsSound struct {
+	ID           string `json:"id"`
+	SkillID      string `json:"skillId"`
+	Size         int64  `json:"size"`
+	OriginalName string `json:"originalName"`
+	CreatedAt    string `json:"createdAt"`
+	IsProcessed  bool   `json:"isProcessed"`
+	Error        string `json:"error"`
+}
+
+type ydsClient struct {
+	url     string
+	skillID string
+	token   string
+	client  *http.Client
+}
+
+type talesSyncOptions struct {
+	manifest string
+	tales    string
+	dryRun   bool
+	keep     bool
+}
+
+func talesUsage() {
+	fmt.Print(`Usage: tales sync [options]
+
+Uploads the parts from the media manifest to Yandex Dialogs sounds, deletes the sounds not used by any tale
+and regenerates yandexDialogs.tales file.
+
+Options:
+--manifest <file>
+  Path to the media manifest, the parts are located in the same directory. Default: media.json
+--tales <file>
+  Path to the tales file. Default: yandexDialogs.tales from the configuration file
+--url <url>
+  Dialogs API URL. Default: yandexDialogs.sounds.url from the configuration file or ` + ydsDefaultURL + `
+--skill <id>
+  Skill identifier. Default: yandexDialogs.sounds.skillId from the configuration file
+--token <token>
+  OAuth token. Default: HOGATE_DIALOGS_TOKEN environment variable or yandexDialogs.sounds.token from the configuration file
+--dry-run
+  Print the changes without applying them
+--keep-orphans
+  Do not delete the sounds not used by any tale
+`)
+}
+
+func talesAction(configFile string, args []string) int {
+	if len(args) < 1 || args[0] != "sync" {
+		talesUsage()
+		return 2
+	}
+
+	var cfg Config
+	cfgPath := ""
+	if data, err := os.ReadFile(configFile); err == nil {
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to parse configuration file: %v\n", err)
+			return 1
+		}
+		cfgPath = filepath.Dir(configFile)
+	}
+
+	yds := &ydsClient{url: ydsDefaultURL, token: os.Getenv("HOGATE_DIALOGS_TOKEN"), client: &http.Client{Timeout: 2 * time.Minute}}
+	opts := talesSyncOptions{manifest: mediaDefaultManifest}
+	if cfg.YandexDialogs != nil {
+		if cfg.YandexDialogs.Tales != "" {
+			opts.tales = cfg.YandexDialogs.Tales
+			if !filepath.IsAbs(opts.tales) {
+				opts.tales = filepath.Join(cfgPath, opts.tales)
+			}
+		}
+		if s := cfg.YandexDialogs.Sounds; s != nil {
+			if s.URL != "" {
+				yds.url = s.URL
+			}
+			yds.skillID = s.SkillID
+			if yds.token == "" {
+				yds.token = s.Token
+			}
+		}
+	}
+
+	fs := flag.NewFlagSet("tales sync", flag.ContinueOnError)
+	fs.Usage = talesUsage
+	fs.StringVar(&opts.manifest, "manifest", opts.manifest, "")
+	fs.StringVar(&opts.tales, "tales", opts.tales, "")
+	fs.StringVar(&yds.url, "url", yds.url, "")
+	fs.StringVar(&yds.skillID, "skill", yds.skillID, "")
+	fs.StringVar(&yds.token, "token", yds.token, "")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "")
+	fs.BoolVar(&opts.keep, "keep-orphans", false, "")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	yds.url = strings.TrimSuffix(yds.url, "/")
+
+	if opts.tales == "" || yds.skillID == "" || yds.token == "" {
+		fmt.Fprintln(os.Stderr, "The tales file, skill identifier and OAuth token are required.")
+		return 2
+	}
+
+	if err := talesSync(yds, &opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func talesSync(yds *ydsClient, opts *talesSyncOptions) error {
+	manifest, err := loadMediaManifest(opts.manifest)
+	if err != nil {
+		return fmt.Errorf("unable to load the manifest: %v", err)
+	}
+
+	var catalog yandexDialogsTalesCatalog
+	if data, err := os.ReadFile(opts.tales); err == nil {
+		if err := yaml.Unmarshal(data, &catalog); err != nil {
+			return fmt.Errorf("unable to parse the tales file: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("unable to read the tales file: %v", err)
+	}
+
+	sounds, err := yds.list()
+	if err != nil {
+		return fmt.Errorf("unable to list the sounds: %v", err)
+	}
+	soundIDs := make(map[string]struct{}, len(sounds))
+	soundNames := make(map[string][]ydsSound, len(sounds))
+	for _, s := range sounds {
+		soundIDs[s.ID] = struct{}{}
+		soundNames[s.OriginalName] = append(soundNames[s.OriginalName], s)
+	}
+
+	// upload new and changed parts
+	dir := filepath.Dir(opts.manifest)
+	used := make(map[string]struct{})
+	for _, t := range manifest.Tales {
+		for _, p := range t.Parts {
+			path := filepath.Join(dir, p.File)
+			fi, hash, err := talesPartHash(path)
+			if err != nil {
+				return fmt.Errorf("unable to read '%v': %v", p.File, err)
+			}
+			if _, ok := soundIDs[p.ID]; ok && p.ID != "" && p.Hash == hash {
+				used[p.ID] = struct{}{}
+				continue
+			}
+			if p.ID == "" {
+				// the part uploaded by the interrupted run: the same name and size, uploaded after the part is produced
+				if id := talesUploadedSound(soundNames[p.File], fi); id != "" {
+					p.ID, p.Hash = id, hash
+					used[id] = struct{}{}
+					continue
+				}
+			}
+			fmt.Printf("upload %v\n", p.File)
+			if opts.dryRun {
+				continue
+			}
+			sound, err := yds.upload(path)
+			if err != nil {
+				return fmt.Errorf("unable to upload '%v': %v", p.File, err)
+			}
+			p.ID, p.Hash = sound.ID, hash
+			used[p.ID] = struct{}{}
+			// the manifest is saved after every upload, so the interrupted run loses at most one sound identifier
+			if err := manifest.save(opts.manifest); err != nil {
+				return fmt.Errorf("unable to save the manifest: %v", err)
+			}
+		}
+	}
+	if !opts.dryRun {
+		if err := manifest.save(opts.manifest); err != nil {
+			return fmt.Errorf("unable to save the manifest: %v", err)
+		}
+	}
+
+	// merge the tales: the tales from the manifest replace the tales with the same name keeping their keys
+	merged := make([]yandexDialogsTale, 0, len(catalog.Tales)+len(manifest.Tales))
+	byName := make(map[string]int)
+	for _, t := range catalog.Tales {
+		byName[t.Name] = len(merged)
+		merged = append(merged, t)
+	}
+	for _, t := range manifest.Tales {
+		tale := yandexDialogsTale{Name: t.Name, Type: t.Type, Length: t.Length}
+		for _, p := range t.Parts {
+			tale.Parts = append(tale.Parts, p.ID)
+		}
+		if i, ok := byName[t.Name]; ok {
+			tale.Keys = merged[i].Keys
+			merged[i] = tale
+		} else {
+			byName[t.Name] = len(merged)
+			merged = append(merged, tale)
+		}
+	}
+	for _, t := range merged {
+		for _, id := range t.Parts {
+			used[id] = struct{}{}
+		}
+	}
+
+	// delete orphans
+	if !opts.keep {
+		for _, s := range sounds {
+			if _, ok := used[s.ID]; ok {
+				continue
+			}
+			fmt.Printf("delete %v (%v)\n", s.OriginalName, s.ID)
+			if opts.dryRun {
+				continue
+			}
+			if err := yds.delete(s.ID); err != nil {
+				return fmt.Errorf("unable to delete '%v': %v", s.ID, err)
+			}
+		}
+	}
+
+	catalog.Tales = merged
+	data, err := yaml.Marshal(catalog)
+	if err != nil {
+		return err
+	}
+	if current, err := os.ReadFile(opts.tales); err == nil && bytes.Equal(current, data) {
+		fmt.Println("tales file is up to date")
+		return nil
+	}
+	fmt.Printf("write %v\n", opts.tales)
+	if opts.dryRun {
+		return nil
+	}
+	tmpPath := opts.tales + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, opts.tales)
+}
+
+// talesPartHash returns the file information and SHA-256 of the part content
+func talesPartHash(path string) (os.FileInfo, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, "", err
+	}
+	return fi, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// talesUploadedSound returns the identifier of the sound with the same size created after the part file was modified
+func talesUploadedSound(sounds []ydsSound, fi os.FileInfo) string {
+	for _, s := range sounds {
+		created, err := time.Parse(time.RFC3339Nano, s.CreatedAt)
+		if err == nil && s.Size == fi.Size() && !created.Before(fi.ModTime().Truncate(time.Second)) {
+			return s.ID
+		}
+	}
+	return ""
+}
+
+func (c *ydsClient) soundsURL() string {
+	return c.url + "/skills/" + url.PathEscape(c.skillID) + "/sounds"
+}
+
+func (c *ydsClient) do(req *http.Request, result interface{}) error {
+	req.Header.Set("Authorization", "OAuth "+c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if result != nil {
+		return json.Unmarshal(body, result)
+	}
+	return nil
+}
+
+func (c *ydsClient) list() ([]ydsSound, error) {
+	req, err := http.NewRequest("GET", c.soundsURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Sounds []ydsSound `json:"sounds"`
+	}
+	if err := c.do(req, &result); err != nil {
+		return nil, err
+	}
+	return result.Sounds, nil
+}
+
+func (c *ydsClient) upload(path string) (*ydsSound, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(fw, f); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", c.soundsURL(), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	var result struct {
+		Sound ydsSound `json:"sound"`
+	}
+	if err := c.do(req, &result); err != nil {
+		return nil, err
+	}
+	if result.Sound.ID == "" {
+		return nil, fmt.Errorf("no sound identifier in the response")
+	}
+	return &result.Sound, nil
+}
+
+func (c *ydsClient) delete(id string) error {
+	req, err := http.NewRequest("DELETE", c.soundsURL()+"/"+url.PathEscape(id), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
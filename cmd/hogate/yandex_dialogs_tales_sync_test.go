@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ydsTestServer imitates the sounds API of Yandex Dialogs, the uploaded sounds get the identifiers new-1, new-2 and so on
+type ydsTestServer struct {
+	lock     sync.Mutex
+	sounds   []ydsSound
+	uploaded []string
+	deleted  []string
+}
+
+func (s *ydsTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	const base = "/skills/skill/sounds"
+	switch {
+	case r.Method == "GET" && r.URL.Path == base:
+		json.NewEncoder(w).Encode(map[string]interface{}{"sounds": s.sounds})
+	case r.Method == "POST" && r.URL.Path == base:
+		f, fh, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.Close()
+		s.uploaded = append(s.uploaded, fh.Filename)
+		sound := ydsSound{ID: "new-" + string(rune('0'+len(s.uploaded))), OriginalName: fh.Filename, Size: fh.Size}
+		s.sounds = append(s.sounds, sound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"sound": sound})
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, base+"/"):
+		id := strings.TrimPrefix(r.URL.Path, base+"/")
+		s.deleted = append(s.deleted, id)
+		for i, sound := range s.sounds {
+			if sound.ID == id {
+				s.sounds = append(s.sounds[:i], s.sounds[i+1:]...)
+				break
+			}
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestTalesSync(t *testing.T) {
+	dir := t.TempDir()
+	parts := map[string]string{"kolobok_000.opus": "new part 0", "kolobok_001.opus": "new part 1", "repka.opus": "repka"}
+	for name, content := range parts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, repkaHash, err := talesPartHash(filepath.Join(dir, "repka.opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// kolobok is split again: its parts have no identifiers and the old sounds have the same names;
+	// kolobok_001.opus was uploaded by the interrupted run after the split
+	manifest := &mediaManifest{Tales: []*mediaManifestTale{
+		{Name: "Колобок", Type: "fairytale", Length: 240, Parts: []*mediaManifestPart{{File: "kolobok_000.opus"}, {File: "kolobok_001.opus"}}},
+		{Name: "Репка", Type: "fairytale", Length: 60, Parts: []*mediaManifestPart{{File: "repka.opus", ID: "repka", Hash: repkaHash}}},
+	}}
+	manifestPath := filepath.Join(dir, mediaDefaultManifest)
+	if err := manifest.save(manifestPath); err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339Nano)
+	future := time.Now().Add(time.Minute).UTC().Format(time.RFC3339Nano)
+	server := &ydsTestServer{sounds: []ydsSound{
+		{ID: "old-0", OriginalName: "kolobok_000.opus", Size: int64(len(parts["kolobok_000.opus"])), CreatedAt: past},
+		{ID: "old-1", OriginalName: "kolobok_001.opus", Size: int64(len(parts["kolobok_001.opus"])), CreatedAt: past},
+		{ID: "resumed-1", OriginalName: "kolobok_001.opus", Size: int64(len(parts["kolobok_001.opus"])), CreatedAt: future},
+		{ID: "repka", OriginalName: "repka.opus", Size: int64(len(parts["repka.opus"])), CreatedAt: past},
+	}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	yds := &ydsClient{url: ts.URL, skillID: "skill", token: "token", client: ts.Client()}
+	opts := &talesSyncOptions{manifest: manifestPath, tales: filepath.Join(dir, "tales.yaml")}
+	if err := talesSync(yds, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.uploaded) != 1 || server.uploaded[0] != "kolobok_000.opus" {
+		t.Errorf("uploaded %v, want [kolobok_000.opus]", server.uploaded)
+	}
+	sort.Strings(server.deleted)
+	if strings.Join(server.deleted, " ") != "old-0 old-1" {
+		t.Errorf("deleted %v, want [old-0 old-1]", server.deleted)
+	}
+
+	saved, err := loadMediaManifest(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, tale := range saved.Tales {
+		for _, p := range tale.Parts {
+			if p.Hash == "" {
+				t.Errorf("%v: no hash", p.File)
+			}
+			ids = append(ids, p.ID)
+		}
+	}
+	if strings.Join(ids, " ") != "new-1 resumed-1 repka" {
+		t.Errorf("part identifiers %v, want [new-1 resumed-1 repka]", ids)
+	}
+
+	// the part changed after the upload is uploaded again
+	if err := os.WriteFile(filepath.Join(dir, "repka.opus"), []byte("repka again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server.uploaded, server.deleted = nil, nil
+	if err := talesSync(yds, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.uploaded) != 1 || server.uploaded[0] != "repka.opus" {
+		t.Errorf("uploaded %v, want [repka.opus]", server.uploaded)
+	}
+	if strings.Join(server.deleted, " ") != "repka" {
+		t.Errorf("deleted %v, want [repka]", server.deleted)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestYandexDialogsTalesReaction(t *testing.T) {
+	ydtLoadTestTales(t)
+	fairytale := ydtTestType(t, "fairytale")
+
+	tests := []struct {
+		request  string
+		reaction ydtReaction
+		data     interface{}
+	}{
+		{"number_select", ydtReactionSelect, yandexDialogsTalesSelect{yandexDialogsTalesItem{fairytale, 2}, true}},
+		{"number_queue", ydtReactionQueue, ydtQueueRequest{fileType: fairytale, playlist: -1, count: 2}},
+		{"number_slice", ydtReactionSlice, yandexDialogsTalesSlice{yandexDialogsTalesItem{fairytale, 1}, 3}},
+		{"number_duration", ydtReactionQueue, ydtQueueRequest{fileType: fairytale, playlist: -1, seconds: 25 * 60}},
+		{"ordinal", ydtReactionSelect, yandexDialogsTalesSelect{yandexDialogsTalesItem{fairytale, 2}, true}},
+		{"yesterday", ydtReactionHistory, YandexDialogsEntityDateTime{Day: -1, DayIsRelative: true}},
+		{"absolute_date", ydtReactionHistory, YandexDialogsEntityDateTime{Day: 5, Month: 3}},
+	}
+	for _, test := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", "yandex_dialogs", test.request+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var req YandexDialogsRequestEnvelope
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Fatalf("%v: %v", test.request, err)
+		}
+		reaction, reactionData := yandexDialogsTalesReaction(*req.Request)
+		if reaction != test.reaction || !reflect.DeepEqual(reactionData, test.data) {
+			t.Errorf("%v: got %v %+v, want %v %+v", test.request, reaction, reactionData, test.reaction, test.data)
+		}
+	}
+}
+
+func TestYDEntitiesByToken(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "yandex_dialogs", "absolute_date.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req YandexDialogsRequestEnvelope
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	entities := ydEntitiesByToken(req.Request.Nlu)
+	if len(entities) != 1 {
+		t.Fatalf("got %v entities, want 1", len(entities))
+	}
+	// the longest entity wins
+	if e := entities[3]; e.Type != ydYandexDateTime || e.Tokens.End != 5 {
+		t.Errorf("got %v at tokens %v..%v, want %v", e.Type, e.Tokens.Start, e.Tokens.End, ydYandexDateTime)
+	}
+}
+
+func TestYDTDayStart(t *testing.T) {
+	loc := time.FixedZone("MSK", 3*60*60)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, loc)
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, loc)
+	}
+
+	tests := []struct {
+		dt   YandexDialogsEntityDateTime
+		day  time.Time
+		name string
+	}{
+		{YandexDialogsEntityDateTime{Day: 0, DayIsRelative: true}, date(2026, 3, 10), "сегодня"},
+		{YandexDialogsEntityDateTime{Day: -1, DayIsRelative: true}, date(2026, 3, 9), "вчера"},
+		{YandexDialogsEntityDateTime{Day: -2, DayIsRelative: true}, date(2026, 3, 8), "позавчера"},
+		{YandexDialogsEntityDateTime{Day: 5, Month: 3}, date(2026, 3, 5), "5 марта"},
+		// the date in the future is the last year or month
+		{YandexDialogsEntityDateTime{Day: 20, Month: 3}, date(2025, 3, 20), "20 марта 2025 года"},
+		{YandexDialogsEntityDateTime{Day: 20}, date(2026, 2, 20), "20 февраля"},
+		{YandexDialogsEntityDateTime{Day: 15, Month: 1, Year: 2025}, date(2025, 1, 15), "15 января 2025 года"},
+		{YandexDialogsEntityDateTime{Month: -1, MonthIsRelative: true}, date(2026, 2, 10), "10 февраля"},
+	}
+	for _, test := range tests {
+		day := ydtDayStart(test.dt, now)
+		if !day.Equal(test.day) {
+			t.Errorf("ydtDayStart(%+v) = %v, want %v", test.dt, day, test.day)
+		}
+		if name := ydtDayName(day, now); name != test.name {
+			t.Errorf("ydtDayName(%v) = %q, want %q", day, name, test.name)
+		}
+	}
+}
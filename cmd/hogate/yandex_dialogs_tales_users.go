@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const ydtMaxHistory = 50
+const ydtMaxFavorites = 50
+const ydtMaxHistoryList = 5
+
+// ydtUserState is remembered between the conversations
+type ydtUserState struct {
+	History   []ydtHistoryEntry `json:"history,omitempty"` // the most recent last
+	Favorites []string          `json:"favorites,omitempty"`
+}
+
+type ydtHistoryEntry struct {
+	Name   string    `json:"name"`
+	Played time.Time `json:"played"`
+	Part   int32     `json:"part,omitempty"` // the part to resume from
+}
+
+var ydtMonthNames = []string{
+	"января", "февраля", "марта", "апреля", "мая", "июня",
+	"июля", "августа", "сентября", "октября", "ноября", "декабря",
+}
+
+// ydtMaxUsers limits the number of the remembered users, the state of the new users over the limit is kept by Yandex only
+const ydtMaxUsers = 10000
+
+// ydtUsers are the users with the remembered state, guarded by ydtUsersLock
+var ydtUsers = make(map[string]*ydtUserState)
+var ydtUsersLock sync.Mutex
+var ydtStateFile string
+
+func validateYandexDialogsTalesUsersConfig(cfgError configError) {
+	ydtUsersLock.Lock()
+	defer ydtUsersLock.Unlock()
+
+	ydtUsers = make(map[string]*ydtUserState)
+	ydtStateFile = ""
+	if config.YandexDialogs == nil || config.YandexDialogs.StateFile == "" {
+		return
+	}
+	ydtStateFile = config.YandexDialogs.StateFile
+
+	data, err := os.ReadFile(ydtStateFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	var users map[string]*ydtUserState
+	if err == nil {
+		err = json.Unmarshal(data, &users)
+	}
+	if err != nil {
+		cfgError(fmt.Sprintf("yandexDialogs.stateFile, unable to load the file '%v': %v", ydtStateFile, err))
+		return
+	}
+	for id, us := range users {
+		if us != nil {
+			ydtUsers[id] = us
+		}
+	}
+}
+
+// ydtUser returns the state of the user restoring it from Yandex user state if the user is not known yet;
+// the user is remembered once the state changes
+func ydtUser(userID string, yandexState interface{}) *ydtUserState {
+	ydtUsersLock.Lock()
+	us, ok := ydtUsers[userID]
+	ydtUsersLock.Unlock()
+	if ok {
+		return us
+	}
+
+	us = &ydtUserState{}
+	if m, ok := yandexState.(map[string]interface{}); ok {
+		if v, ok := m["favorites"].(string); ok && v != "" {
+			us.Favorites = strings.Split(v, "\n")
+		}
+		if v, ok := m["last"].(string); ok && v != "" {
+			us.History = []ydtHistoryEntry{{Name: v}}
+		}
+	}
+	return us
+}
+
+// ydtUpdateUser applies the change to the user state, persists it and returns the Yandex user state update
+func ydtUpdateUser(userID string, us *ydtUserState, change func(us *ydtUserState) bool) interface{} {
+	ydtUsersLock.Lock()
+	defer ydtUsersLock.Unlock()
+
+	if !change(us) {
+		return nil
+	}
+	if known, ok := ydtUsers[userID]; ok {
+		if known != us {
+			// the concurrent request of the same user remembered it first
+			change(known)
+			us = known
+		}
+		ydtSaveUsers()
+	} else if len(ydtUsers) < ydtMaxUsers {
+		ydtUsers[userID] = us
+		ydtSaveUsers()
+	}
+
+	update := map[string]interface{}{
+		"favorites": strings.Join(us.Favorites, "\n"),
+	}
+	if l := len(us.History); l > 0 {
+		update["last"] = us.History[l-1].Name
+	}
+	return update
+}
+
+func ydtSaveUsers() {
+	if ydtStateFile == "" {
+		return
+	}
+	data, err := json.Marshal(ydtUsers)
+	if err != nil {
+		return
+	}
+	tmpFile := ydtStateFile + ".tmp"
+	if os.WriteFile(tmpFile, data, 0600) == nil {
+		os.Rename(tmpFile, ydtStateFile)
+	}
+}
+
+func (us *ydtUserState) played(name string, part int32) bool {
+	if l := len(us.History); l > 0 && us.History[l-1].Name == name && part > 0 {
+		// the same tale continues
+		us.History[l-1].Played = time.Now()
+		us.History[l-1].Part = part
+		return true
+	}
+	us.History = append(us.History, ydtHistoryEntry{Name: name, Played: time.Now(), Part: part})
+	if l := len(us.History); l > ydtMaxHistory {
+		us.History = us.History[l-ydtMaxHistory:]
+	}
+	return true
+}
+
+func (us *ydtUserState) addFavorite(name string) bool {
+	for _, f := range us.Favorites {
+		if f == name {
+			return false
+		}
+	}
+	us.Favorites = append(us.Favorites, name)
+	if l := len(us.Favorites); l > ydtMaxFavorites {
+		us.Favorites = us.Favorites[l-ydtMaxFavorites:]
+	}
+	return true
+}
+
+func (us *ydtUserState) removeFavorite(name string) bool {
+	for i, f := range us.Favorites {
+		if f == name {
+			us.Favorites = append(us.Favorites[:i], us.Favorites[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// recent returns the tales played during the day starting at from, or recently if from is zero
+func (us *ydtUserState) recent(from time.Time) []yandexDialogsTalesItem {
+	ydtUsersLock.Lock()
+	defer ydtUsersLock.Unlock()
+
+	to := from.AddDate(0, 0, 1)
+
+	var items []yandexDialogsTalesItem
+	seen := make(map[string]struct{})
+	for i := len(us.History) - 1; i >= 0 && len(items) < ydtMaxHistoryList; i-- {
+		h := us.History[i]
+		if !from.IsZero() && (h.Played.Before(from) || !h.Played.Before(to)) {
+			continue
+		}
+		if _, ok := seen[h.Name]; ok {
+			continue
+		}
+		seen[h.Name] = struct{}{}
+		if item, ok := ydtFindTale(h.Name); ok {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (us *ydtUserState) last() (yandexDialogsTalesItem, int32, bool) {
+	ydtUsersLock.Lock()
+	defer ydtUsersLock.Unlock()
+
+	for i := len(us.History) - 1; i >= 0; i-- {
+		if item, ok := ydtFindTale(us.History[i].Name); ok {
+			return item, us.History[i].Part, true
+		}
+	}
+	return yandexDialogsTalesItem{}, 0, false
+}
+
+func (us *ydtUserState) favorites() []yandexDialogsTalesItem {
+	ydtUsersLock.Lock()
+	defer ydtUsersLock.Unlock()
+
+	var items []yandexDialogsTalesItem
+	for _, name := range us.Favorites {
+		if item, ok := ydtFindTale(name); ok {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ydtFindTale finds the tale by name; the names are kept instead of indexes since the tales file could change
+func ydtFindTale(name string) (yandexDialogsTalesItem, bool) {
+	for ft, fs := range ydtFileTypes {
+		for i, f := range fs {
+			if f.name == name {
+				return yandexDialogsTalesItem{ft, int32(i)}, true
+			}
+		}
+	}
+	return yandexDialogsTalesItem{}, false
+}
+
+func ydtTaleName(item yandexDialogsTalesItem) string {
+	if f, ok := ydtFileTypes[item.fileType]; ok && item.index >= 0 && int(item.index) < len(f) {
+		return f[item.index].name
+	}
+	return ""
+}
+
+// ydtDayStart returns the start of the day of the date entity relative to now, the day in the past is assumed if the date is not complete
+func ydtDayStart(dt YandexDialogsEntityDateTime, now time.Time) time.Time {
+	loc := now.Location()
+	y, m, d := now.Date()
+	if dt.YearIsRelative {
+		y += dt.Year
+	} else if dt.Year != 0 {
+		y = dt.Year
+	}
+	if dt.MonthIsRelative {
+		m += time.Month(dt.Month)
+	} else if dt.Month != 0 {
+		m = time.Month(dt.Month)
+	}
+	if dt.DayIsRelative {
+		d += dt.Day
+	} else if dt.Day != 0 {
+		d = dt.Day
+	}
+	day := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	if day.After(now) && !dt.DayIsRelative && !dt.MonthIsRelative && !dt.YearIsRelative {
+		if dt.Year != 0 {
+			return day
+		} else if dt.Month != 0 {
+			day = day.AddDate(-1, 0, 0)
+		} else {
+			day = day.AddDate(0, -1, 0)
+		}
+	}
+	return day
+}
+
+// ydtDayName returns "сегодня", "вчера" or the date
+func ydtDayName(day time.Time, now time.Time) string {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch {
+	case day.Equal(today):
+		return "сегодня"
+	case day.Equal(today.AddDate(0, 0, -1)):
+		return "вчера"
+	case day.Equal(today.AddDate(0, 0, -2)):
+		return "позавчера"
+	}
+	name := fmt.Sprintf("%v %v", day.Day(), ydtMonthNames[day.Month()-1])
+	if day.Year() != today.Year() {
+		name += fmt.Sprintf(" %v года", day.Year())
+	}
+	return name
+}
+
+func ydtCapitalize(s string) string {
+	r, n := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[n:]
+}
+
+func ydtTimezone(meta *YandexDialogsMeta) *time.Location {
+	if meta != nil && meta.Timezone != "" {
+		if loc, err := time.LoadLocation(meta.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
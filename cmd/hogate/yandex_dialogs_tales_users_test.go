@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func ydtTestUsers(t *testing.T) string {
+	t.Helper()
+	savedUsers, savedFile := ydtUsers, ydtStateFile
+	t.Cleanup(func() { ydtUsers, ydtStateFile = savedUsers, savedFile })
+	ydtUsers = make(map[string]*ydtUserState)
+	ydtStateFile = filepath.Join(t.TempDir(), "state.json")
+	return ydtStateFile
+}
+
+func ydtTestStateUsers(t *testing.T, stateFile string) map[string]*ydtUserState {
+	t.Helper()
+	var users map[string]*ydtUserState
+	data, err := os.ReadFile(stateFile)
+	if err == nil {
+		err = json.Unmarshal(data, &users)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func TestYDTUserRememberedOnChange(t *testing.T) {
+	stateFile := ydtTestUsers(t)
+
+	us := ydtUser("visitor", map[string]interface{}{"favorites": "Колобок\nРепка"})
+	if len(us.Favorites) != 2 {
+		t.Errorf("favorites from Yandex user state: got %v", us.Favorites)
+	}
+	if _, ok := ydtUsers["visitor"]; ok {
+		t.Errorf("the user is remembered without any change")
+	}
+	if update := ydtUpdateUser("visitor", us, func(us *ydtUserState) bool { return us.removeFavorite("Теремок") }); update != nil {
+		t.Errorf("no change: got update %v", update)
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Errorf("the state file is written without any change: %v", err)
+	}
+
+	update := ydtUpdateUser("visitor", us, func(us *ydtUserState) bool { return us.addFavorite("Теремок") })
+	if update == nil || ydtUsers["visitor"] != us {
+		t.Fatalf("the changed user is not remembered")
+	}
+	if users := ydtTestStateUsers(t, stateFile); len(users["visitor"].Favorites) != 3 {
+		t.Errorf("state file: got %+v", users["visitor"])
+	}
+	if ydtUser("visitor", nil) != us {
+		t.Errorf("the remembered user is not returned")
+	}
+}
+
+func TestYDTUsersLimit(t *testing.T) {
+	stateFile := ydtTestUsers(t)
+	for i := 0; i < ydtMaxUsers; i++ {
+		ydtUsers[fmt.Sprint("user-", i)] = &ydtUserState{Favorites: []string{"Колобок"}}
+	}
+
+	// the new users don't push the remembered ones out
+	for i := 0; i < 10; i++ {
+		id := fmt.Sprint("new-", i)
+		us := ydtUser(id, nil)
+		update := ydtUpdateUser(id, us, func(us *ydtUserState) bool { return us.played("Репка", 0) })
+		if m, ok := update.(map[string]interface{}); !ok || m["last"] != "Репка" {
+			t.Errorf("%v: got update %v", id, update)
+		}
+	}
+	if len(ydtUsers) != ydtMaxUsers {
+		t.Errorf("got %v users, want %v", len(ydtUsers), ydtMaxUsers)
+	}
+
+	us := ydtUser("user-0", nil)
+	ydtUpdateUser("user-0", us, func(us *ydtUserState) bool { return us.addFavorite("Репка") })
+	users := ydtTestStateUsers(t, stateFile)
+	if len(users) != ydtMaxUsers || len(users["user-0"].Favorites) != 2 {
+		t.Errorf("state file: got %v users, user-0 %+v", len(users), users["user-0"])
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestYDReplayGuardAccept(t *testing.T) {
+	var g ydReplayGuard
+	if !g.accept("skill", "session", 1) {
+		t.Fatal("the first message is rejected")
+	}
+	if g.accept("skill", "session", 1) || g.accept("skill", "session", 0) {
+		t.Fatal("the replayed message is accepted")
+	}
+	if !g.accept("skill", "session", 2) {
+		t.Fatal("the next message is rejected")
+	}
+	if !g.accept("other", "session", 1) {
+		t.Fatal("the message of other skill is rejected")
+	}
+}
+
+func TestYDReplayGuardFull(t *testing.T) {
+	var g ydReplayGuard
+	for i := 0; i < ydMaxTrackedSessions+10; i++ {
+		if !g.accept("skill", fmt.Sprintf("flood-%v", i), 0) {
+			t.Fatalf("the new session %v is rejected", i)
+		}
+	}
+	if len(g.sessions) > ydMaxTrackedSessions {
+		t.Fatalf("%v sessions are tracked, the limit is %v", len(g.sessions), ydMaxTrackedSessions)
+	}
+	if !g.accept("skill", "real", 0) {
+		t.Fatal("the real session is rejected when the table is full")
+	}
+	if g.accept("skill", "real", 0) {
+		t.Fatal("the replay of the real session is accepted")
+	}
+}
+
+func TestYDTestCommand(t *testing.T) {
+	send := func(skill *ydSkill) *YandexDialogsResponseEnvelope {
+		body, _ := json.Marshal(YandexDialogsRequestEnvelope{
+			Request: &YandexDialogsRequest{Command: "test", OriginalUtterance: "test"},
+			Session: YandexDialogsRequestSession{SessionID: "session", SkillID: "skill", UserID: "user"},
+			Version: "1.0",
+		})
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		yandexDialogsHandler(skill).ServeHTTP(w, r)
+		var resp YandexDialogsResponseEnvelope
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("code %v, body %q: %v", w.Code, w.Body.String(), err)
+		}
+		return &resp
+	}
+
+	open := &ydSkill{kind: "tales", accountLinking: true, handler: ydtSkill{}}
+	if resp := send(open); resp.AccountLinking == nil {
+		t.Errorf("the test command of the skill without skill IDs and secret: no account linking, got %q", resp.Response.Text)
+	}
+	for _, skill := range []*ydSkill{
+		{kind: "tales", accountLinking: true, handler: ydtSkill{}, skillIDs: map[string]struct{}{"skill": {}}},
+		{kind: "tales", accountLinking: true, handler: ydtSkill{}, secret: true},
+	} {
+		if resp := send(skill); resp.AccountLinking != nil || resp.Response.Text != "test" {
+			t.Errorf("the test command of the protected skill: got %q", resp.Response.Text)
+		}
+	}
+}
@@ -24,10 +24,13 @@ func yandexHomeUnlink(w http.ResponseWriter, r *http.Request) {
 
 func yandexHomeDevices(w http.ResponseWriter, r *http.Request) {
 	claim := httpAuthorization(r)
+	visible := yxhDeviceAccess(claim)
 
 	devices := make([]YandexHomeDevice, 0, len(yxhDevices))
 	for _, v := range yxhDevices {
-		devices = append(devices, v.yandex())
+		if visible(v) {
+			devices = append(devices, v.yandex())
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -45,10 +48,11 @@ func yandexHomeQuery(w http.ResponseWriter, r *http.Request) {
 	if !parseJSONRequest(&req, w, r) {
 		return
 	}
+	visible := yxhDeviceAccess(httpAuthorization(r))
 
 	devices := make([]YandexHomeDeviceState, 0, len(req.Devices))
 	for _, v := range req.Devices {
-		if di, ok := yxhDevices[v.ID]; ok {
+		if di, ok := yxhDevices[v.ID]; ok && visible(di) {
 			devices = append(devices, di.query())
 		} else {
 			devices = append(devices, YandexHomeDeviceState{ID: v.ID, ErrorCode: yhDeviceErrorNotFound})
@@ -69,10 +73,11 @@ func yandexHomeAction(w http.ResponseWriter, r *http.Request) {
 	if !parseJSONRequest(&req, w, r) {
 		return
 	}
+	visible := yxhDeviceAccess(httpAuthorization(r))
 
 	devices := make([]YandexHomeDeviceActionResult, 0, len(req.Payload.Devices))
 	for _, v := range req.Payload.Devices {
-		if di, ok := yxhDevices[v.ID]; ok {
+		if di, ok := yxhDevices[v.ID]; ok && visible(di) {
 			devices = append(devices, di.action(v.Capabilities))
 		} else {
 			devices = append(devices, YandexHomeDeviceActionResult{
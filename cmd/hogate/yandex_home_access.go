@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+)
+
+type yxhAccessRule struct {
+	users   scopeSet
+	groups  scopeSet
+	devices scopeSet
+	rooms   scopeSet
+	tags    scopeSet
+}
+
+var yxhAccessRules []yxhAccessRule
+
+func validateYandexHomeAccessConfig(cfgError configError) {
+	yxhAccessRules = nil
+	if config.YandexHome == nil {
+		return
+	}
+
+	for i, a := range config.YandexHome.Access {
+		accessError := func(msg string) {
+			cfgError(fmt.Sprintf("yandexHome.access, rule %v: %v", i, msg))
+		}
+
+		rule := yxhAccessRule{
+			users:   parseScope(a.Users),
+			groups:  parseScope(a.Groups),
+			devices: newScopeSet(a.Devices...),
+			rooms:   newScopeSet(a.Rooms...),
+			tags:    newScopeSet(a.Tags...),
+		}
+
+		if len(rule.users) == 0 && len(rule.groups) == 0 {
+			accessError("at least one user or group must be specified.")
+		}
+		if len(rule.devices) == 0 && len(rule.rooms) == 0 && len(rule.tags) == 0 {
+			accessError("at least one device, room or tag must be specified.")
+		}
+		for id := range rule.devices {
+			if _, ok := yxhDevices[id]; !ok {
+				accessError(fmt.Sprintf("unknown device '%v'.", id))
+			}
+		}
+
+		yxhAccessRules = append(yxhAccessRules, rule)
+	}
+}
+
+func (a yxhAccessRule) matchCaller(name string, groups scopeSet) bool {
+	if _, ok := a.users[name]; ok {
+		return true
+	}
+	for g := range groups {
+		if _, ok := a.groups[g]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (a yxhAccessRule) matchDevice(d yxhDevice) bool {
+	if _, ok := a.devices[d.id]; ok {
+		return true
+	}
+	if _, ok := a.rooms[d.room]; ok && d.room != "" {
+		return true
+	}
+	for _, t := range d.tags {
+		if _, ok := a.tags[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// yxhDeviceAccess returns the device visibility test for the caller identified by the token claim.
+// Callers which are not mentioned by any access rule see all devices; otherwise only
+// the devices matched by at least one of the caller's rules are visible.
+func yxhDeviceAccess(claim *AuthTokenClaims) func(d yxhDevice) bool {
+	if claim == nil || len(yxhAccessRules) == 0 {
+		return func(d yxhDevice) bool { return true }
+	}
+
+	name := claim.UserName
+	var groups scopeSet
+	if name == "" {
+		name = claim.ClientID
+	} else if ui, ok := credentials.user(name); ok {
+		groups = ui.groups
+	}
+
+	var rules []yxhAccessRule
+	for _, rule := range yxhAccessRules {
+		if rule.matchCaller(name, groups) {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return func(d yxhDevice) bool { return true }
+	}
+
+	return func(d yxhDevice) bool {
+		for _, rule := range rules {
+			if rule.matchDevice(d) {
+				return true
+			}
+		}
+		return false
+	}
+}
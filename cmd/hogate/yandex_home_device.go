@@ -63,6 +63,7 @@ type yxhDevice struct {
 	name         string
 	description  string
 	room         string
+	tags         []string
 	devType      yxhDeviceType
 	zwID         byte
 	capabilities []yxhCapability
@@ -109,6 +110,8 @@ func validateYandexHomeConfig(cfgError configError) {
 			yxhDevices[device.id] = device
 		}
 	}
+
+	validateYandexHomeAccessConfig(cfgError)
 }
 
 func parseDevice(d YandexHomeDeviceConfig, cfgError configError) yxhDevice {
@@ -121,6 +124,7 @@ func parseDevice(d YandexHomeDeviceConfig, cfgError configError) yxhDevice {
 	rv.name = d.Name
 	rv.description = d.Description
 	rv.room = d.Room
+	rv.tags = d.Tags
 	if rv.devType, ok = parseDeviceType(d.Type); !ok {
 		cfgError(fmt.Sprintf("invalid type '%v'.", d.Type))
 	}
@@ -370,6 +374,10 @@ func (d yxhDevice) query() (rv YandexHomeDeviceState) {
 			case yxhDeviceTypeLight, yxhDeviceTypeSocket, yxhDeviceTypeSwitch:
 				capState, errorCode = yxhQueryBasicOnOff(d.zwID)
 			}
+		case yxhCapabilityRange:
+			if p, ok := c.parameters.(yxhParamRange); ok && p.instance == yxhRangeTemperature {
+				capState, errorCode = yxhQueryRangeTemperature(d.zwID, p.units)
+			}
 		}
 
 		if errorCode != "" || capState == nil {
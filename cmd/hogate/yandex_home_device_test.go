@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// yxhStubZwCmd writes the script which answers like zwcmd does: node 2 is on, the thermostats 3 and 4 are set to 21.5 °C
+func yxhStubZwCmd(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the stub zwcmd is the shell script")
+	}
+	stub := filepath.Join(t.TempDir(), "zwcmd")
+	script := `#!/bin/sh
+case "$*" in
+*"basic 2 --get") echo '<zwt code="0" value="255"/>' ;;
+*"setpoint 3 --get") echo '<zwt code="0" value="21.5" scale="0"/>' ;;
+*"setpoint 4 --get") echo '<zwt code="0" value="70.7" scale="1"/>' ;;
+*) echo '<zwt code="2147483643"/>' ;;
+esac
+`
+	if err := os.WriteFile(stub, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	saved := zwCmd
+	t.Cleanup(func() { zwCmd = saved })
+	zwCmd = stub
+}
+
+func yxhTestThermostat(id string, zwID byte, units yxhUnitsType) yxhDevice {
+	return yxhDevice{
+		id:      id,
+		name:    "Кондиционер",
+		room:    "Спальня",
+		devType: yxhDeviceTypeThermostatAC,
+		zwID:    zwID,
+		capabilities: []yxhCapability{{
+			capType:     yxhCapabilityRange,
+			retrievable: true,
+			parameters:  yxhParamRange{instance: yxhRangeTemperature, units: units, min: 16, max: 30, precision: 0.5},
+		}},
+	}
+}
+
+func TestYXHDeviceQueryTemperature(t *testing.T) {
+	yxhStubZwCmd(t)
+
+	tests := []struct {
+		device yxhDevice
+		value  float64
+	}{
+		{yxhTestThermostat("celsius", 3, yxhUnitsCelsius), 21.5},
+		{yxhTestThermostat("fahrenheit", 4, yxhUnitsCelsius), 21.5},
+		{yxhTestThermostat("kelvin", 3, yxhUnitsKelvin), 294.65},
+	}
+	for _, test := range tests {
+		state := test.device.query()
+		if state.ErrorCode != "" || len(state.Capabilities) != 1 {
+			t.Errorf("%v: error %q, capabilities %+v", test.device.id, state.ErrorCode, state.Capabilities)
+			continue
+		}
+		c := state.Capabilities[0]
+		v, ok := c.State.Value.(float64)
+		if c.Type != yhDeviceCapRange || c.State.Instance != yhCapRangeInstanceTemperature || !ok || v < test.value-0.01 || v > test.value+0.01 {
+			t.Errorf("%v: got %+v, want temperature %v", test.device.id, c, test.value)
+		}
+	}
+
+	if state := yxhTestThermostat("unreachable", 5, yxhUnitsCelsius).query(); state.ErrorCode != yhDeviceErrorUnreachable {
+		t.Errorf("unreachable: got error %q", state.ErrorCode)
+	}
+}
@@ -26,6 +26,26 @@ func yxhQueryBasicOnOff(nodeID byte) (capState *YandexHomeCapabilityState, error
 	return
 }
 
+func yxhQueryRangeTemperature(nodeID byte, units yxhUnitsType) (capState *YandexHomeCapabilityState, errorCode string) {
+	code, value, scale := zwThermostatSetpointGet(nodeID)
+	if errorCode = yxhZwRetCode(code); errorCode == "" {
+		if scale == zwScaleFahrenheit {
+			value = (value - 32) * 5 / 9
+		}
+		if units == yxhUnitsKelvin {
+			value += 273.15
+		}
+		capState = &YandexHomeCapabilityState{
+			Type: yhDeviceCapRange,
+			State: YandexHomeState{
+				Instance: yhCapRangeInstanceTemperature,
+				Value:    value,
+			},
+		}
+	}
+	return
+}
+
 func yxhActionBasicOnOff(nodeID byte, value bool) (errorCode string) {
 	v := byte(0)
 	if value {
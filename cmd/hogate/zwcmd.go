@@ -149,6 +149,27 @@ func zwBasicSet(nodeID byte, level byte) int {
 	return code
 }
 
+// Thermostat Setpoint scales
+const (
+	zwScaleCelsius    = 0
+	zwScaleFahrenheit = 1
+)
+
+// zwThermostatSetpointGet returns the temperature set on the thermostat and its scale
+func zwThermostatSetpointGet(nodeID byte) (int, float64, int) {
+	code, attr := zwCommand("setpoint", strconv.Itoa(int(nodeID)), "--get")
+	if code == zwSuccess {
+		if value, ok := attr["value"]; ok {
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				scale, _ := strconv.Atoi(attr["scale"])
+				return code, v, scale
+			}
+		}
+		return zwQueryFailed, 0, 0
+	}
+	return code, 0, 0
+}
+
 func zwBasicGet(nodeID byte) (int, byte) {
 	code, attr := zwCommand("basic", strconv.Itoa(int(nodeID)), "--get")
 	if code == zwSuccess {
@@ -3,11 +3,13 @@ module github.com/stas-makutin/hogate
 go 1.26.5
 
 require (
+	github.com/andybalholm/brotli v1.2.6
 	github.com/aws/aws-lambda-go v1.54.0
 	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/google/uuid v1.6.0
 	github.com/hbollon/go-edlib v1.7.0
 	github.com/kardianos/service v1.3.0
+	github.com/klauspost/compress v1.20.1
 	golang.org/x/crypto v0.54.0
 	golang.org/x/net v0.57.0
 	golang.org/x/time v0.15.0